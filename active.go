@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+/* ─── recently active profiles ────────────────────────────────────────────── */
+
+// activeWithin (ACTIVE_WITHIN, e.g. 30d) skips restore-size, raw-data and
+// blobs-per-file for profiles whose latest snapshot is older, keeping their
+// last known values. 0 = always compute everything.
+var activeWithin = getActiveWithin()
+
+func getActiveWithin() time.Duration {
+	d, err := parseSince(os.Getenv("ACTIVE_WITHIN"))
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// inactiveSince reports whether none of snaps is younger than ACTIVE_WITHIN.
+func inactiveSince(snaps []snapshotEntry) bool {
+	cutoff := clock().Add(-activeWithin)
+	for _, s := range snaps {
+		if t, err := time.Parse(time.RFC3339, s.Time); err == nil && t.After(cutoff) {
+			return false
+		}
+	}
+	return true
+}
+
+// lastKnown is the profile as of the last refresh, if there was one.
+func lastKnown(name string) (ProfileStats, bool) {
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	for _, p := range cachedProfiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return ProfileStats{}, false
+}
+
+// keepExpensive copies what the skipped stats commands produce from prev.
+func keepExpensive(p *ProfileStats, prev ProfileStats) {
+	p.RestoreBytes, p.RestoreHuman, p.RestoreFiles = prev.RestoreBytes, prev.RestoreHuman, prev.RestoreFiles
+	p.FilesPerSnapshot = prev.FilesPerSnapshot
+	p.RawBytes, p.RawHuman, p.RawBlobs = prev.RawBytes, prev.RawHuman, prev.RawBlobs
+	p.UncompBytes, p.UncompHuman = prev.UncompBytes, prev.UncompHuman
+	p.CompressRatio, p.CompressRatioHuman = prev.CompressRatio, prev.CompressRatioHuman
+	p.CompressionSavingPc, p.CompressionSavingHuman = prev.CompressionSavingPc, prev.CompressionSavingHuman
+	p.SavedBytes, p.SavedHuman = prev.SavedBytes, prev.SavedHuman
+	p.CompressionProgPct = prev.CompressionProgPct
+	p.LastMaintenance, p.SizeTrend = prev.LastMaintenance, prev.SizeTrend
+	p.BlobsPerFile = prev.BlobsPerFile
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+/* ─── effective configuration ─────────────────────────────────────────────── */
+
+// configHandler serves /config: the settings as resolved from the
+// environment, keyed by their variable, so operators can check what took
+// effect inside the container. There is no authentication (see README), so
+// anything secret is redacted.
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	seconds := func(d time.Duration) int64 { return int64(d / time.Second) }
+	opts := conf()
+	var exitCodes []int
+	for c := range acceptedExitCodes {
+		exitCodes = append(exitCodes, c)
+	}
+	sort.Ints(exitCodes)
+	timeoutSecs := map[string]int64{}
+	for k, d := range opts.timeouts {
+		if k == "" {
+			k = "default"
+		}
+		timeoutSecs[k] = seconds(d)
+	}
+	overrides := map[string]interface{}{}
+	for dir, o := range opts.overrides {
+		overrides[dir] = map[string]interface{}{
+			"timeout": seconds(o.Timeout), "stale": seconds(o.Stale), "name": o.DisplayName, "args": o.Args,
+		}
+	}
+	cfg := map[string]interface{}{
+		"version":                   version,
+		"DATA_ROOT":                 dataRoot,
+		"RESTICPROFILE_BINARY":      resticBinary,
+		"RESTIC_BINARY":             resticBin,
+		"COMMAND_STYLE":             commandStyle,
+		"SOURCE_MODE":               sourceMode,
+		"LISTEN_ADDR":               listenAddr,
+		"ROUTE_PREFIX":              routePrefix,
+		"STATS_ROUTE":               statsRoute,
+		"CACHE_SECONDS":             opts.cacheSeconds,
+		"CACHE_SECONDS_SET":         opts.cacheSecondsSet,
+		"BACKGROUND_REFRESH":        bgRefresh,
+		"REFRESH_ON_STARTUP":        refreshOnStartup,
+		"CONCURRENCY":               concurrency,
+		"COMMAND_CONCURRENCY":       cap(commandSlots),
+		"SKIP_STATS":                skipStats,
+		"SNAPSHOTS_LIMIT":           snapshotsLimit,
+		"ACTIVE_WITHIN":             seconds(activeWithin),
+		"STATS_MODES":               setKeys(statsModes),
+		"DISABLE_STATS":             setKeys(opts.disabledStats),
+		"SERVE_STALE":               opts.serveStale,
+		"MAX_STALE_SECONDS":         opts.maxStale,
+		"RESTIC_TIMEOUT":            timeoutSecs,
+		"PROFILE_TIMEOUT":           seconds(opts.profileTimeout),
+		"SLOW_COMMAND_SECONDS":      seconds(opts.slowCommand),
+		"RESTIC_JSON_ONLY":          jsonOnly,
+		"STRICT_JSON":               strictJSON,
+		"STRICT_CONFIG":             strictConfig,
+		"STRICT_GENERATION":         strictGeneration,
+		"ACCEPTED_EXIT_CODES":       exitCodes,
+		"JSON_CASE":                 jsonCase,
+		"JSON_BUFFER_MAX_BYTES":     maxPooledBuffer,
+		"RATIO_PRECISION":           ratioPrecision,
+		"HUMANIZE_STYLE":            humanStyle,
+		"TIME_JUST_NOW_SECONDS":     seconds(timeJustNow),
+		"TIME_RELATIVE_MAX_SECONDS": seconds(timeRelativeMax),
+		"MAX_DEPTH":                 maxDepth,
+		"DISCOVERY_CONCURRENCY":     discoveryConcurrency,
+		"DISCOVERY_CACHE_SECONDS":   seconds(discoveryTTL),
+		"REPO_ID_CACHE_SECONDS":     seconds(repoIDTTL),
+		"PROFILE_GROUPS":            groups,
+		"GROUP_MODE":                groupMode,
+		"PROFILE_SCOPES":            profileScopes,
+		"PROFILE_OVERRIDES":         overrides,
+		"STALE_THRESHOLD_SECONDS":   seconds(opts.staleThreshold),
+		"HEALTH_MIN_SNAPSHOTS":      opts.healthMinSnapshots,
+		"HEALTH_MAX_AGE_SECONDS":    seconds(opts.healthMaxAge),
+		"CHECK_LOCKS":               checkLocksEnabled,
+		"LOCK_STALE_SECONDS":        seconds(opts.lockStaleAfter),
+		"LAST_DELTA":                lastDelta,
+		"SIZE_TREND_ALPHA":          trendAlpha,
+		"MEMORY_PRESSURE_FRACTION":  memoryPressure,
+		"MAX_CONCURRENT_REQUESTS":   maxRequests,
+		"METRICS_PER_PATH":          metricsPerPath,
+		"STDOUT_METRICS":            stdoutMetrics,
+		"OFFLINE_FALLBACK":          offlineFallback,
+		"OFFLINE_CACHE_FILE":        offlineFile,
+		"WATCH_MODE":                watchMode,
+		"WATCH_DEBOUNCE_SECONDS":    seconds(watchDebounce),
+		"ENABLE_EXPVAR":             enableExpvar,
+		"ENABLE_UI":                 enableUI,
+		"ONESHOT":                   oneshot,
+		"SYSLOG_ADDR":               syslogAddr,
+		"SYSLOG_TAG":                syslogTag,
+		"CONFIG_FILE":               configFile,
+		"REDIS_URL":                 redactURL(redisURL),
+		"REDIS_KEY":                 redisKey,
+		"REDIS_LOCK_SECONDS":        seconds(redisLockTTL),
+		"REDIS_WAIT_SECONDS":        seconds(redisWait),
+	}
+	cacheMu.RLock()
+	cfg["cache_ttl_seconds"] = seconds(cachedTTL) // CACHE_SECONDS or derived from the schedules
+	cacheMu.RUnlock()
+	// keys are variable names, so no JSON_CASE or ?fields
+	w.Header().Set("Content-Type", "application/json")
+	_ = writeJSONResponse(w, http.StatusOK, cfg, jsonOpts{pretty: r.URL.Query().Get("pretty") == "true", rawKeys: true})
+}
+
+// redactURL hides the password of a URL like redis://:secret@host.
+func redactURL(v string) string {
+	u, err := url.Parse(v)
+	if err != nil {
+		return "xxxxx" // unparseable, it might be nothing but a secret
+	}
+	return u.Redacted()
+}
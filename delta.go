@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+/* ─── data added by the latest snapshot ───────────────────────────────────── */
+
+var lastDelta = os.Getenv("LAST_DELTA") == "true"
+
+// snapshotSummaryJSON is the part of a snapshot's "summary" (restic 0.17+)
+// we need.
+type snapshotSummaryJSON struct {
+	DataAdded *int64 `json:"data_added"`
+}
+
+// diffStatsJSON is the final message of `restic diff --json`.
+type diffStatsJSON struct {
+	MessageType string `json:"message_type"`
+	Added       struct {
+		Bytes int64 `json:"bytes"`
+	} `json:"added"`
+}
+
+// latestAdded returns how much data the latest snapshot added. Snapshots
+// made by restic 0.17 or later carry it in their summary; for older ones it
+// takes a `diff` against the parent, which has to read both trees.
+func latestAdded(ctx context.Context, dir string, s snapshotEntry) (int64, error) {
+	var sum snapshotSummaryJSON
+	if len(s.Summary) > 0 && json.Unmarshal(s.Summary, &sum) == nil && sum.DataAdded != nil {
+		return *sum.DataAdded, nil
+	}
+	if s.Parent == "" {
+		return 0, errors.New("no summary and no parent snapshot to diff against")
+	}
+	var added int64
+	found := false
+	err := runLines(ctx, dir, "diff", []string{s.Parent, s.ID, "--json"}, func(line []byte) {
+		if !bytes.Contains(line, []byte(`"statistics"`)) {
+			return // one message per changed file
+		}
+		var st diffStatsJSON
+		if json.Unmarshal(line, &st) == nil && st.MessageType == "statistics" {
+			added, found = st.Added.Bytes, true
+		}
+	})
+	if err == nil && !found {
+		err = errNoJSON
+	}
+	return added, err
+}
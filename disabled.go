@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/* ─── disabled profiles ───────────────────────────────────────────────────── */
+
+// disabledMarker in a profile directory takes it out of polling, e.g. for
+// archived repositories. Its content, if any, is shown as the reason.
+const disabledMarker = ".disabled"
+
+type DisabledProfile struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason,omitempty"`
+}
+
+var (
+	disabledMu       sync.Mutex
+	disabledProfiles = []DisabledProfile{}
+)
+
+func isDisabled(dir string) bool {
+	return fileExists(filepath.Join(dir, disabledMarker))
+}
+
+// splitDisabled removes the profiles with a marker from names and records
+// them for /stats/disabled.
+func splitDisabled(names []string) []string {
+	active := names[:0:0]
+	disabled := []DisabledProfile{}
+	for _, n := range names {
+		dir := filepath.Join(dataRoot, n)
+		if !isDisabled(dir) {
+			active = append(active, n)
+			continue
+		}
+		reason, _ := os.ReadFile(filepath.Join(dir, disabledMarker))
+		disabled = append(disabled, DisabledProfile{Name: n, Reason: strings.TrimSpace(string(reason))})
+	}
+	disabledMu.Lock()
+	disabledProfiles = disabled
+	disabledMu.Unlock()
+	return active
+}
+
+// disabledHandler lists the profiles skipped because of their marker file.
+func disabledHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := listProfiles(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	disabledMu.Lock()
+	list := disabledProfiles
+	disabledMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	_ = writeJSONResponse(w, http.StatusOK, list, jsonOptions(r))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* ─── profile discovery ───────────────────────────────────────────────────── */
+
+var (
+	maxDepth             int           // MAX_DEPTH, 1 = direct subdirectories only
+	discoveryConcurrency int           // parallel os.ReadDir calls while discovering
+	discoveryTTL         time.Duration // DISCOVERY_CACHE_SECONDS, 0 = list on every refresh
+
+	discoveryMu    sync.Mutex
+	discoveredAt   time.Time
+	discoveredDirs []string
+)
+
+func init() {
+	maxDepth = getenvInt("MAX_DEPTH", 1)
+	discoveryConcurrency = getenvInt("DISCOVERY_CONCURRENCY", 8)
+	discoveryTTL = time.Duration(getenvInt("DISCOVERY_CACHE_SECONDS", 0)) * time.Second
+}
+
+// listProfiles is discoverProfiles on DATA_ROOT without the disabled
+// profiles, cached for DISCOVERY_CACHE_SECONDS because listing can be slow
+// on network filesystems. New directories show up once the listing has
+// expired.
+func listProfiles() ([]string, error) {
+	discoveryMu.Lock()
+	defer discoveryMu.Unlock()
+	if discoveredDirs != nil && clock().Sub(discoveredAt) < discoveryTTL {
+		return append([]string(nil), discoveredDirs...), nil
+	}
+	names, err := discoverProfiles(dataRoot, maxDepth, discoveryConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	names = splitDisabled(names) // never nil, so an empty listing is cached too
+	discoveredDirs, discoveredAt = names, clock()
+	return append([]string(nil), names...), nil
+}
+
+// profileConfigNames are the files resticprofile looks for by default.
+var profileConfigNames = []string{
+	"profiles.yaml", "profiles.yml", "profiles.toml", "profiles.json", "profiles.hcl", "profiles.conf",
+}
+
+// discoverProfiles returns the profile directories below root as paths
+// relative to root, sorted by name. A directory is a profile when it sits at
+// maxDepth or contains a resticprofile configuration (a repository file with
+// COMMAND_STYLE=restic); other directories are descended into (hidden ones
+// are skipped). With maxDepth 1 every direct subdirectory is a profile.
+func discoverProfiles(root string, maxDepth, concurrency int) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	// A fixed pool of workers takes directories off a shared queue, so both
+	// the goroutines and the file system calls (up to len(profileConfigNames)
+	// stats and one ReadDir per directory) are bounded by concurrency.
+	type pending struct {
+		rel   string
+		depth int
+	}
+	var (
+		mu     sync.Mutex
+		more   = sync.NewCond(&mu) // queue grew or the last busy worker finished
+		queue  []pending
+		busy   int
+		names  []string
+		wg     sync.WaitGroup
+		hidden = func(name string) bool { return strings.HasPrefix(name, ".") }
+	)
+	for _, e := range entries {
+		// hidden top-level dirs stay profiles with maxDepth 1, as they always were
+		if e.IsDir() && (maxDepth <= 1 || !hidden(e.Name())) {
+			queue = append(queue, pending{e.Name(), 1})
+		}
+	}
+	// visit reports whether rel is a profile, or else its subdirectories
+	visit := func(p pending) (bool, []pending) {
+		dir := filepath.Join(root, p.rel)
+		if p.depth >= maxDepth || hasProfileConfig(dir) {
+			return true, nil
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return false, nil
+		}
+		var subdirs []pending
+		for _, e := range entries {
+			if e.IsDir() && !hidden(e.Name()) {
+				subdirs = append(subdirs, pending{filepath.Join(p.rel, e.Name()), p.depth + 1})
+			}
+		}
+		return false, subdirs
+	}
+	for range max(1, concurrency) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			for {
+				for len(queue) == 0 && busy > 0 {
+					more.Wait()
+				}
+				if len(queue) == 0 { // and nobody can add to it any more
+					return
+				}
+				p := queue[len(queue)-1]
+				queue = queue[:len(queue)-1]
+				busy++
+				mu.Unlock()
+				isProfile, subdirs := visit(p)
+				mu.Lock()
+				busy--
+				if isProfile {
+					names = append(names, p.rel)
+				}
+				queue = append(queue, subdirs...)
+				more.Broadcast()
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Strings(names)
+	return names, nil
+}
+
+func hasProfileConfig(dir string) bool {
+	if commandStyle == "restic" {
+		return fileExists(filepath.Join(dir, "repository"))
+	}
+	for _, n := range profileConfigNames {
+		if _, err := os.Stat(filepath.Join(dir, n)); err == nil {
+			return true
+		}
+	}
+	return false
+}
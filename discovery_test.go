@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// makeTree creates hosts×profiles profile directories two levels below
+// root, each with a profiles.yaml, plus a hidden directory per host that
+// discovery has to skip.
+func makeTree(tb testing.TB, root string, hosts, profiles int) {
+	tb.Helper()
+	for h := range hosts {
+		host := filepath.Join(root, fmt.Sprintf("host%03d", h))
+		if err := os.MkdirAll(filepath.Join(host, ".snapshots"), 0o755); err != nil {
+			tb.Fatal(err)
+		}
+		for p := range profiles {
+			dir := filepath.Join(host, fmt.Sprintf("profile%03d", p))
+			if err := os.MkdirAll(filepath.Join(dir, "cache"), 0o755); err != nil {
+				tb.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "profiles.yaml"), []byte("version: \"1\"\n"), 0o644); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestDiscoverProfiles(t *testing.T) {
+	root := t.TempDir()
+	makeTree(t, root, 3, 4)
+	// without a config a directory is only a profile at maxDepth
+	if err := os.MkdirAll(filepath.Join(root, "plain", "a", "b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for h := range 3 {
+		for p := range 4 {
+			want = append(want, filepath.Join(fmt.Sprintf("host%03d", h), fmt.Sprintf("profile%03d", p)))
+		}
+	}
+	want = append([]string{filepath.Join("plain", "a", "b")}, want...)
+	slices.Sort(want)
+	for _, concurrency := range []int{0, 1, 4, 64} {
+		got, err := discoverProfiles(root, 3, concurrency)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("concurrency %d: got %q, want %q", concurrency, got, want)
+		}
+	}
+
+	got, err := discoverProfiles(root, 1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"host000", "host001", "host002", "plain"}; !slices.Equal(got, want) {
+		t.Errorf("MAX_DEPTH 1: got %q, want %q", got, want)
+	}
+}
+
+func BenchmarkDiscoverProfiles(b *testing.B) {
+	root := b.TempDir()
+	const hosts, profiles = 50, 40
+	makeTree(b, root, hosts, profiles)
+	for _, concurrency := range []int{1, 8, 32} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			for b.Loop() {
+				names, err := discoverProfiles(root, 4, concurrency)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(names) != hosts*profiles {
+					b.Fatalf("found %d profiles, want %d", len(names), hosts*profiles)
+				}
+			}
+		})
+	}
+}
+
+// TestListProfilesPicksUpNewDirs checks that a profile directory created
+// while the server runs shows up once the cached listing has expired.
+func TestListProfilesPicksUpNewDirs(t *testing.T) {
+	useFixtures(t, "files")
+	dataRoot = t.TempDir()
+	now := time.Now()
+	oldClock, oldTTL, oldDepth := clock, discoveryTTL, maxDepth
+	clock, discoveryTTL, maxDepth = func() time.Time { return now }, time.Minute, 1
+	t.Cleanup(func() { clock, discoveryTTL, maxDepth = oldClock, oldTTL, oldDepth })
+
+	mkdir := func(name string) {
+		if err := os.Mkdir(filepath.Join(dataRoot, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := func() []string {
+		names, err := listProfiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return names
+	}
+
+	mkdir("first")
+	if got := list(); !slices.Equal(got, []string{"first"}) {
+		t.Fatalf("got %q, want [first]", got)
+	}
+	mkdir("second")
+	now = now.Add(30 * time.Second)
+	if got := list(); !slices.Equal(got, []string{"first"}) {
+		t.Errorf("within DISCOVERY_CACHE_SECONDS: got %q, want the cached [first]", got)
+	}
+	now = now.Add(31 * time.Second)
+	if got := list(); !slices.Equal(got, []string{"first", "second"}) {
+		t.Errorf("after DISCOVERY_CACHE_SECONDS: got %q, want [first second]", got)
+	}
+
+	discoveryTTL = 0
+	mkdir("third")
+	if got := list(); !slices.Equal(got, []string{"first", "second", "third"}) {
+		t.Errorf("without DISCOVERY_CACHE_SECONDS: got %q, want all three at once", got)
+	}
+}
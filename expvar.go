@@ -0,0 +1,28 @@
+package main
+
+import (
+	"expvar"
+	"os"
+)
+
+/* ─── expvar ──────────────────────────────────────────────────────────────── */
+
+// enableExpvar serves the standard expvar page at /debug/vars (memstats,
+// cmdline and our counters under "resticprofile").
+var enableExpvar = os.Getenv("ENABLE_EXPVAR") == "true"
+
+func init() {
+	if !enableExpvar {
+		return
+	}
+	expvar.Publish("resticprofile", expvar.Func(func() interface{} {
+		return map[string]interface{}{
+			"cache_hits":               cacheHits.Load(),
+			"cache_misses":             cacheMisses.Load(),
+			"last_refresh_duration_ms": lastRefreshMs.Load(),
+			"profiles_total":           profilesTotal.Load(),
+			"profiles_ok":              profilesOK.Load(),
+			"profiles_failed":          profilesFailed.Load(),
+		}
+	}))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+/* ─── failure tracking ────────────────────────────────────────────────────── */
+
+// commandError is returned by collectProfile when one of the restic commands
+// for a profile fails.
+type commandError struct {
+	Command string // e.g. "raw-data", "snapshots"
+	Dir     string
+	Err     error
+}
+
+func (e *commandError) Error() string {
+	return fmt.Sprintf("%s for %s: %v", e.Command, e.Dir, e.Err)
+}
+
+func (e *commandError) Unwrap() error { return e.Err }
+
+// ProfileFailure describes a profile whose last refresh failed.
+type ProfileFailure struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+	Error   string `json:"error"`
+	Since   int64  `json:"since"` // unix time of the first failure in a row
+
+	TimedOut bool `json:"timed_out,omitempty"` // ran out of PROFILE_TIMEOUT
+}
+
+var (
+	failuresMu sync.Mutex
+	failures   = map[string]ProfileFailure{}
+)
+
+// recordResult updates the failure list after a profile was collected. A
+// profile that keeps failing keeps its original Since.
+func recordResult(name string, err error) {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+	if err == nil {
+		delete(failures, name)
+		return
+	}
+	f := ProfileFailure{Name: name, Error: err.Error(), Since: clock().Unix()}
+	f.TimedOut = errors.Is(err, errProfileTimeout)
+	var ce *commandError
+	if errors.As(err, &ce) {
+		f.Command = ce.Command
+		f.Error = ce.Err.Error()
+	}
+	if old, ok := failures[name]; ok {
+		f.Since = old.Since
+	}
+	failures[name] = f
+}
+
+// pruneFailures forgets profiles that no longer exist.
+func pruneFailures(names []string) {
+	keep := make(map[string]bool, len(names))
+	for _, n := range names {
+		keep[n] = true
+	}
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+	for n := range failures {
+		if !keep[n] {
+			delete(failures, n)
+		}
+	}
+}
+
+func currentFailures() []ProfileFailure {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+	out := make([]ProfileFailure, 0, len(failures))
+	for _, f := range failures {
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// failuresHandler lists the profiles whose last refresh failed. It goes
+// through getStats() so the list is as fresh as the cached stats.
+func failuresHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := getStats(r.Context()); err != nil {
+		statsError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = writeJSONResponse(w, http.StatusOK, currentFailures(), jsonOptions(r))
+}
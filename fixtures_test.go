@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useFixtures points the server at testdata/profiles, either through
+// fake-resticprofile (SOURCE_MODE=commands) or by reading the recordings
+// directly (SOURCE_MODE=files), and puts everything back when the test ends.
+func useFixtures(t *testing.T, mode string) {
+	t.Helper()
+	root, err := filepath.Abs("testdata/profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake, err := filepath.Abs("testdata/fake-resticprofile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldRoot, oldBinary, oldMode, oldStyle := dataRoot, resticBinary, sourceMode, commandStyle
+	oldLocks, oldDelta, oldSettings := checkLocksEnabled, lastDelta, conf()
+	dataRoot, resticBinary, sourceMode, commandStyle = root, fake, mode, "resticprofile"
+	checkLocksEnabled, lastDelta = true, true
+	s := oldSettings
+	s.disabledStats = map[string]bool{} // every profile has a restore-size recording
+	setSettings(s)
+	resetDiscovery()
+	t.Cleanup(func() {
+		dataRoot, resticBinary, sourceMode, commandStyle = oldRoot, oldBinary, oldMode, oldStyle
+		checkLocksEnabled, lastDelta = oldLocks, oldDelta
+		setSettings(oldSettings)
+		resetDiscovery()
+	})
+}
+
+func resetDiscovery() {
+	discoveryMu.Lock()
+	discoveredDirs = nil
+	discoveryMu.Unlock()
+}
+
+func setSettings(s settings) {
+	settingsMu.Lock()
+	current = s
+	settingsMu.Unlock()
+}
+
+// TestFixtures checks the outcomes testdata/README.md promises for each
+// recorded profile.
+func TestFixtures(t *testing.T) {
+	for _, mode := range []string{"commands", "files"} {
+		t.Run(mode, func(t *testing.T) {
+			useFixtures(t, mode)
+			stats, err := generateStats(context.Background(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			byName := map[string]ProfileStats{}
+			for _, p := range stats {
+				byName[p.Name] = p
+			}
+
+			if _, ok := byName["nooutput"]; ok {
+				t.Error("nooutput: collected, want a failure")
+			}
+			failuresMu.Lock()
+			f, ok := failures["nooutput"]
+			failuresMu.Unlock()
+			if !ok || f.Command != "raw-data" || f.Error != errNoJSON.Error() {
+				t.Errorf("nooutput: failure %+v, want raw-data %q", f, errNoJSON)
+			}
+
+			basic := byName["basic"]
+			if basic.RepoVersion != 2 || basic.CompressRatio <= 1 {
+				t.Errorf("basic: repo version %d, compression ratio %v, want a compressed v2 repo", basic.RepoVersion, basic.CompressRatio)
+			}
+			if len(basic.Paths) < 2 {
+				t.Errorf("basic: %d paths, want several", len(basic.Paths))
+			}
+			if basic.ExpectedIntervalSeconds != 86400 {
+				t.Errorf("basic: expected interval %ds, want the daily schedule", basic.ExpectedIntervalSeconds)
+			}
+			if mode == "commands" && (basic.Locks != 1 || !basic.HasStaleLock) { // files mode cannot list locks
+				t.Errorf("basic: %d locks (stale %v), want one stale lock", basic.Locks, basic.HasStaleLock)
+			}
+			if basic.LastSnapshotAdded <= 0 {
+				t.Errorf("basic: last snapshot added %d bytes, want the summary's data_added", basic.LastSnapshotAdded)
+			}
+
+			if empty, ok := byName["empty"]; !ok || empty.Snapshots != 0 || empty.LastSnapshotID != "" {
+				t.Errorf("empty: %+v, want a profile without snapshots", empty)
+			}
+			if got := byName["nocompression"].CompressRatioHuman; got != "unsupported" {
+				t.Errorf("nocompression: compression ratio %q, want unsupported", got)
+			}
+			wrapped := byName["wrapped"]
+			if wrapped.Snapshots != 2 {
+				t.Errorf("wrapped: %d snapshots, want the 2 in the wrapper", wrapped.Snapshots)
+			}
+			if mode == "commands" && wrapped.LastSnapshotAdded <= 0 { // files mode cannot diff
+				t.Errorf("wrapped: last snapshot added %d bytes, want the diff against its parent", wrapped.LastSnapshotAdded)
+			}
+			for name, want := range map[string]int64{"basic": 4685851012530, "empty": 0, "wrapped": 1073741824, "sametime": 1610612736} {
+				if got := byName[name].RestoreBytes; got != want {
+					t.Errorf("%s: restore size %d, want %d", name, got, want)
+				}
+			}
+			if basic.FilesPerSnapshot <= 0 {
+				t.Errorf("basic: %v files per snapshot, want restore_files / snapshots", basic.FilesPerSnapshot)
+			}
+			if got := byName["sametime"].LastSnapshotID; got != "4890f39e" {
+				t.Errorf("sametime: last snapshot %q, want 4890f39e", got)
+			}
+		})
+	}
+}
+
+// TestFixturesWithoutRestoreSize checks that files_per_snapshot is left out
+// rather than reported as 0 when restore-size, the default, is disabled.
+func TestFixturesWithoutRestoreSize(t *testing.T) {
+	useFixtures(t, "commands")
+	s := conf()
+	s.disabledStats = map[string]bool{"restore-size": true}
+	setSettings(s)
+	stats, err := generateStats(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range stats {
+		if p.FilesPerSnapshot != 0 {
+			t.Errorf("%s: %v files per snapshot, want none", p.Name, p.FilesPerSnapshot)
+		}
+	}
+	b, err := json.Marshal(stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("files_per_snapshot")) {
+		t.Errorf("JSON has files_per_snapshot: %s", b)
+	}
+	var metrics bytes.Buffer
+	writeProfileMetrics(&metrics, stats)
+	if strings.Contains(metrics.String(), "resticprofile_files_per_snapshot{") {
+		t.Errorf("metrics have a files_per_snapshot sample:\n%s", metrics.String())
+	}
+}
+
+// TestFixturesConcurrent generates the fixtures with several workers, for
+// -race: the result must be in directory order and the same as with one.
+func TestFixturesConcurrent(t *testing.T) {
+	useFixtures(t, "commands")
+	now := time.Now()
+	oldClock, oldConcurrency, oldSlots := clock, concurrency, commandSlots
+	clock = func() time.Time { return now } // same "x ago" strings in both runs
+	t.Cleanup(func() { clock, concurrency, commandSlots = oldClock, oldConcurrency, oldSlots })
+
+	generate := func(workers int) []ProfileStats {
+		concurrency, commandSlots = workers, make(chan struct{}, workers)
+		var mu sync.Mutex
+		published := map[string]bool{}
+		stats, err := generateStats(context.Background(), func(p ProfileStats) {
+			mu.Lock()
+			published[p.Name] = true
+			mu.Unlock()
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range stats {
+			if !published[stats[i].Name] {
+				t.Errorf("%d workers: %s not published", workers, stats[i].Name)
+			}
+			// differ from run to run
+			stats[i].RefreshDurationMs, stats[i].SizeTrend, stats[i].LastMaintenance = 0, "", 0
+		}
+		return stats
+	}
+
+	want := generate(1)
+	var names []string
+	for _, p := range want {
+		names = append(names, p.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"basic", "empty", "nocompression", "sametime", "wrapped"}) {
+		t.Fatalf("sequential run: profiles %q, want the fixtures without nooutput in directory order", names)
+	}
+	for range 3 {
+		if got := generate(8); !reflect.DeepEqual(got, want) {
+			t.Errorf("8 workers: got %+v, want %+v", got, want)
+		}
+	}
+}
+
+// TestFixturesNoJSON checks the error itself, not just its text.
+func TestFixturesNoJSON(t *testing.T) {
+	useFixtures(t, "commands")
+	var raw rawJSON
+	err := runAndParse(context.Background(), filepath.Join(dataRoot, "nooutput"), "stats", "raw-data", nil, &raw)
+	if !errors.Is(err, errNoJSON) {
+		t.Fatalf("got %v, want %v", err, errNoJSON)
+	}
+}
+
+// TestRunAndParseBadOutput checks that a command whose output cannot be
+// parsed is still read to the end and waited for, instead of being left
+// blocked on a full pipe.
+func TestRunAndParseBadOutput(t *testing.T) {
+	useFixtures(t, "commands")
+	oldJSONOnly := jsonOnly
+	t.Cleanup(func() { jsonOnly = oldJSONOnly })
+	for name, first := range map[string]string{
+		"decode error": `echo '{"total_size": nope}'`,
+		"long line":    `head -c 100000 /dev/zero | tr '\0' x; echo`,
+	} {
+		for _, only := range []bool{false, true} {
+			if only && name == "long line" {
+				continue // JSON_ONLY doesn't scan lines
+			}
+			jsonOnly = only
+			dir := t.TempDir()
+			script := filepath.Join(dir, "restic")
+			// more than a pipe buffer after the bad part, then a marker
+			body := "#!/bin/sh\n" + first + "\nhead -c 200000 /dev/zero | tr '\\0' y\ntouch done\n"
+			if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			resticBinary = script
+			var raw rawJSON
+			var err error
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second) // the blocked command gets killed
+			captureStdout(t, func() {
+				err = runAndParse(ctx, dir, "stats", "raw-data", nil, &raw)
+			})
+			if err == nil {
+				t.Errorf("%s (JSON_ONLY %v): no error", name, only)
+			}
+			if _, statErr := os.Stat(filepath.Join(dir, "done")); statErr != nil {
+				t.Errorf("%s (JSON_ONLY %v): command not run to the end: %v", name, only, statErr)
+			}
+			cancel()
+		}
+	}
+}
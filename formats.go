@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/* ─── alternative output formats ──────────────────────────────────────────── */
+
+// responseFormat picks the output format from ?format= or the Accept header.
+func responseFormat(r *http.Request) string {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/vnd.influx") {
+		return "influx"
+	}
+	return "json"
+}
+
+// writeNDJSON streams one profile per line, flushing after each so clients
+// can start processing before the whole response is written.
+func writeNDJSON(w http.ResponseWriter, res []ProfileStats, v2 bool, o jsonOpts) {
+	flusher, _ := w.(http.Flusher)
+	o.pretty = false // one line per profile
+	for _, p := range res {
+		var err error
+		if v2 {
+			err = writeJSON(w, toV2(p), o)
+		} else {
+			err = writeJSON(w, p, o)
+		}
+		if err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+var influxTagEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+// writeInflux emits one InfluxDB line-protocol point per profile, stamped
+// with the time the data was generated.
+func writeInflux(w io.Writer, res []ProfileStats, at time.Time) {
+	ts := at.UnixNano()
+	for _, p := range res {
+		fmt.Fprintf(w, "resticprofile,profile=%s "+
+			"restore_bytes=%di,restore_files=%di,raw_bytes=%di,uncompressed_bytes=%di,"+
+			"compression_ratio=%g,compression_space_saving=%g,compression_progress=%di,"+
+			"raw_blob_count=%di,snapshots=%di,last_snapshot_unix=%di %d\n",
+			influxTagEscaper.Replace(p.Name),
+			p.RestoreBytes, p.RestoreFiles, p.RawBytes, p.UncompBytes,
+			p.CompressRatio, p.CompressionSavingPc, p.CompressionProgPct,
+			p.RawBlobs, p.Snapshots, p.LastSnapshotUnix, ts)
+	}
+}
@@ -1,3 +1,7 @@
 module github.com/i5heu/resticprofile-stat-server
 
 go 1.24.2
+
+require github.com/fsnotify/fsnotify v1.9.0
+
+require golang.org/x/sys v0.13.0 // indirect
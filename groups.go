@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+/* ─── profile groups ──────────────────────────────────────────────────────── */
+
+// Group modes (GROUP_MODE)
+const (
+	groupModeOff  = "off"  // individual profiles only (default)
+	groupModeBoth = "both" // individual profiles followed by one row per group
+	groupModeOnly = "only" // one row per group, members are not listed
+)
+
+type profileGroup struct {
+	Name    string
+	Members []string // profile directory names
+}
+
+var (
+	groups    []profileGroup
+	groupMode string
+)
+
+func init() {
+	groups = parseGroups(os.Getenv("PROFILE_GROUPS"))
+	groupMode = getenvOr("GROUP_MODE", groupModeOff)
+}
+
+// parseGroups parses "name=dir1,dir2;other=dir3".
+func parseGroups(v string) []profileGroup {
+	var out []profileGroup
+	for _, def := range strings.Split(v, ";") {
+		name, members, ok := strings.Cut(strings.TrimSpace(def), "=")
+		if !ok || name == "" {
+			continue
+		}
+		g := profileGroup{Name: strings.TrimSpace(name)}
+		for _, m := range strings.Split(members, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				g.Members = append(g.Members, m)
+			}
+		}
+		out = append(out, g)
+	}
+	return out
+}
+
+// applyGroups adds (or, with GROUP_MODE=only, substitutes) one aggregated
+// row per configured group.
+func applyGroups(stats []ProfileStats) []ProfileStats {
+	if groupMode == groupModeOff || len(groups) == 0 {
+		return stats
+	}
+	byName := make(map[string]ProfileStats, len(stats))
+	for _, p := range stats {
+		byName[p.Name] = p
+	}
+	var out []ProfileStats
+	if groupMode == groupModeBoth {
+		out = append(out, stats...)
+	}
+	for _, g := range groups {
+		var members []ProfileStats
+		for _, m := range g.Members {
+			if p, ok := byName[m]; ok {
+				members = append(members, p)
+			} else {
+				fmt.Printf("group %s: member %s not found\n", g.Name, m)
+			}
+		}
+		out = append(out, aggregateGroup(g.Name, members))
+	}
+	return out
+}
+
+// aggregateGroup sums the member sizes and counts. The group's last snapshot
+// is the oldest of the members' latest snapshots, so one stale member makes
+// the whole group look stale.
+func aggregateGroup(name string, members []ProfileStats) ProfileStats {
+	g := ProfileStats{Name: name, Paths: []PathSnapshot{}}
+	var progWeighted float64
+	var oldest int64
+	unsupported := 0 // members without compression
+	for i, p := range members {
+		if p.CompressRatioHuman == compressionUnsupported {
+			unsupported++
+		}
+		g.Members = append(g.Members, p.Name)
+		g.RestoreBytes += p.RestoreBytes
+		g.RestoreFiles += p.RestoreFiles
+		g.RawBytes += p.RawBytes
+		g.UncompBytes += p.UncompBytes
+		g.RawBlobs += p.RawBlobs
+		g.Snapshots += p.Snapshots
+		g.SnapshotsPerDay += p.SnapshotsPerDay
+		g.LargestGapSeconds = max(g.LargestGapSeconds, p.LargestGapSeconds)
+		// only meaningful for the oldest-snapshot rule if all members agree
+		if i == 0 || p.ExpectedIntervalSeconds == g.ExpectedIntervalSeconds {
+			g.ExpectedIntervalSeconds = p.ExpectedIntervalSeconds
+		} else {
+			g.ExpectedIntervalSeconds = 0
+		}
+		g.RefreshDurationMs += p.RefreshDurationMs
+		progWeighted += float64(p.CompressionProgPct) * float64(p.RawBytes)
+		if i == 0 || p.LastSnapshotUnix < oldest {
+			oldest = p.LastSnapshotUnix
+			g.LastSnapshotID = p.LastSnapshotID
+		}
+		// the lowest known format, so a group shows it still needs an upgrade
+		if p.RepoVersion != 0 && (g.RepoVersion == 0 || p.RepoVersion < g.RepoVersion) {
+			g.RepoVersion = p.RepoVersion
+		}
+		g.CoveredPaths += p.CoveredPaths // member paths are kept apart, see below
+		if p.OldestPathSnapshotUnix != 0 && (g.OldestPathSnapshotUnix == 0 || p.OldestPathSnapshotUnix < g.OldestPathSnapshotUnix) {
+			g.OldestPathSnapshotUnix = p.OldestPathSnapshotUnix
+		}
+		g.Locks += p.Locks
+		g.LastSnapshotAdded += p.LastSnapshotAdded
+		g.HasStaleLock = g.HasStaleLock || p.HasStaleLock
+		if p.Offline { // one offline member makes the group offline
+			g.Offline = true
+			if g.OfflineSince == 0 || p.OfflineSince < g.OfflineSince {
+				g.OfflineSince = p.OfflineSince
+			}
+		}
+		if i == 0 || p.LastMaintenance < g.LastMaintenance {
+			g.LastMaintenance = p.LastMaintenance
+		}
+		for _, w := range p.Warnings {
+			g.Warnings = append(g.Warnings, p.Name+": "+w)
+		}
+		for _, ps := range p.Paths {
+			ps.Path = p.Name + ":" + ps.Path
+			g.Paths = append(g.Paths, ps)
+		}
+	}
+	if g.RawBytes > 0 {
+		g.CompressRatio = float64(g.UncompBytes) / float64(g.RawBytes)
+		g.CompressionProgPct = int64(progWeighted / float64(g.RawBytes))
+	}
+	if g.UncompBytes > 0 {
+		g.CompressionSavingPc = (1 - float64(g.RawBytes)/float64(g.UncompBytes)) * 100
+	}
+
+	g.FilesPerSnapshot = perSnapshot(g.RestoreFiles, g.Snapshots)
+	g.RestoreHuman = human(g.RestoreBytes)
+	g.RawHuman = human(g.RawBytes)
+	g.UncompHuman = human(g.UncompBytes)
+	g.LastSnapshotAddedHuman = addedHuman(g.LastSnapshotAdded)
+	g.CompressRatioHuman = ratioHuman(g.CompressRatio)
+	g.CompressionSavingHuman = percentHuman(g.CompressionSavingPc)
+	g.SavedBytes = savedBytes(g.UncompBytes, g.RawBytes)
+	g.SavedHuman = human(g.SavedBytes)
+	if unsupported == len(members) && len(members) > 0 {
+		g.CompressRatioHuman, g.CompressionSavingHuman, g.SavedHuman = compressionUnsupported, compressionUnsupported, compressionUnsupported
+	}
+
+	g.LastSnapshotUnix = oldest
+	if oldest != 0 {
+		g.LastSnapshot = prettyTime(time.Unix(oldest, 0))
+		g.LastSnapshotISO = isoOrEmpty(time.Unix(oldest, 0))
+	} else {
+		g.LastSnapshot = prettyTime(time.Time{})
+	}
+	return g
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/* ─── health ──────────────────────────────────────────────────────────────── */
+
+type healthResponse struct {
+	Status   string            `json:"status"` // ok, degraded or down
+	Checks   map[string]string `json:"checks"`
+	Profiles []ProfileHealth   `json:"profiles,omitempty"`
+}
+
+type ProfileHealth struct {
+	Name      string `json:"name"`
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+}
+
+// healthHandler checks the binary and DATA_ROOT. With ?deep=true it also
+// probes every repository; it is only "down" (503) when none is reachable,
+// so a single flaky remote does not look like a broken deployment.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	res := healthResponse{Status: "ok", Checks: basicChecks()}
+	for _, c := range res.Checks {
+		if c != "ok" {
+			res.Status = "down"
+		}
+	}
+
+	if res.Status == "ok" && r.URL.Query().Get("deep") == "true" {
+		names, err := listProfiles()
+		if err != nil {
+			res.Status = "down"
+			res.Checks["discovery"] = err.Error()
+		} else {
+			res.Profiles = probeProfiles(names)
+			reachable := 0
+			for _, p := range res.Profiles {
+				if p.Reachable {
+					reachable++
+				}
+			}
+			switch {
+			case len(res.Profiles) > 0 && reachable == 0:
+				res.Status = "down"
+			case reachable < len(res.Profiles):
+				res.Status = "degraded"
+			}
+		}
+	}
+
+	status := http.StatusOK
+	if res.Status == "down" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = writeJSONResponse(w, status, res, jsonOptions(r))
+}
+
+// basicChecks reports "ok" or the problem for each local precondition.
+func basicChecks() map[string]string {
+	checks := map[string]string{"binary": "ok", "data_root": "ok"}
+	bin := resticBinary
+	if commandStyle == "restic" {
+		bin = resticBin
+	}
+	if sourceMode == "files" {
+		checks["binary"] = "not used"
+	} else if _, err := exec.LookPath(bin); err != nil {
+		checks["binary"] = err.Error()
+	}
+	if fi, err := os.Stat(dataRoot); err != nil {
+		checks["data_root"] = err.Error()
+	} else if !fi.IsDir() {
+		checks["data_root"] = fmt.Sprintf("%s is not a directory", dataRoot)
+	}
+	return checks
+}
+
+// probeProfiles runs `cat config` against each profile, CONCURRENCY at a
+// time. Results keep the order of names.
+func probeProfiles(names []string) []ProfileHealth {
+	out := make([]ProfileHealth, len(names))
+	sem := make(chan struct{}, max(1, concurrency))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			var cfg repoConfigJSON
+			err := runAndParse(context.Background(), filepath.Join(dataRoot, name), "cat", "", []string{"config"}, &cfg)
+			out[i] = ProfileHealth{Name: name, Reachable: err == nil}
+			if err != nil {
+				out[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+/* profile health rules */
+
+// withHealth returns copies of the profiles with Healthy, HealthReasons and
+// IsStale set. It runs when serving, not when collecting, so the age is
+// current.
+func withHealth(in []ProfileStats) []ProfileStats {
+	out := make([]ProfileStats, len(in))
+	for i, p := range in {
+		out[i] = evaluateHealth(p)
+	}
+	return out
+}
+
+// evaluateHealth applies the rules every dashboard should agree on: a recent
+// enough snapshot and at least HEALTH_MIN_SNAPSHOTS of them. The count is
+// unknown with SKIP_STATS and not checked then.
+func evaluateHealth(p ProfileStats) ProfileStats {
+	var reasons []string
+	opts := conf()
+	maxAge := opts.healthMaxAge
+	if maxAge == 0 {
+		maxAge = staleThreshold(p)
+	}
+	if age, ok := snapshotAge(p); !ok {
+		reasons = append(reasons, "no snapshot")
+	} else if age > maxAge {
+		reasons = append(reasons, fmt.Sprintf("last snapshot %s ago, limit %s", age.Round(time.Minute), maxAge))
+	}
+	if p.Offline {
+		reasons = append(reasons, "repository offline since "+time.Unix(p.OfflineSince, 0).UTC().Format(time.RFC3339))
+	}
+	if !skipStats && p.Snapshots < int64(opts.healthMinSnapshots) {
+		reasons = append(reasons, fmt.Sprintf("%d snapshots, want at least %d", p.Snapshots, opts.healthMinSnapshots))
+	}
+	p.Healthy = len(reasons) == 0
+	p.HealthReasons = reasons
+	p.IsStale = staleFor(p, 0)
+	return p
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* ─── JSON output ─────────────────────────────────────────────────────────── */
+
+// jsonOpts are the per-request JSON output options.
+type jsonOpts struct {
+	omitHuman    bool            // ?human=false
+	pretty       bool            // ?pretty=true, only where whole documents are written
+	fields       map[string]bool // ?fields=name,raw_bytes; nil = all
+	profileDepth int             // depth of the profile fields, 2 for the v2 wrapper
+	rawKeys      bool            // keys are data (e.g. variable names), JSON_CASE leaves them alone
+}
+
+func jsonOptions(r *http.Request) jsonOpts {
+	o := jsonOpts{
+		omitHuman:    r.URL.Query().Get("human") == "false",
+		pretty:       r.URL.Query().Get("pretty") == "true",
+		profileDepth: 1,
+	}
+	if f := r.URL.Query().Get("fields"); f != "" {
+		o.fields = parseSet(f)
+	}
+	return o
+}
+
+// key maps an output key to its final name, or drops it.
+func (o jsonOpts) key(k string, depth int) (string, bool) {
+	if o.omitHuman && isHumanKey(k) {
+		return "", false
+	}
+	camel := snakeToCamel(k)
+	if o.fields != nil && depth == o.profileDepth && !o.fields[k] && !o.fields[camel] {
+		return "", false
+	}
+	if o.camel() {
+		k = camel
+	}
+	return k, true
+}
+
+// rewrites reports whether the output differs from plain json.Marshal.
+func (o jsonOpts) rewrites() bool {
+	return o.camel() || o.omitHuman || o.fields != nil
+}
+
+func (o jsonOpts) camel() bool {
+	return jsonCase == "camel" && !o.rawKeys
+}
+
+// isHumanKey reports whether k holds a human readable string that has a
+// numeric counterpart (e.g. raw_human next to raw_bytes).
+func isHumanKey(k string) bool {
+	return k == "human" || k == "last_snapshot" || k == "last" || strings.HasSuffix(k, "_human")
+}
+
+// responseBuffers are reused by writeJSONResponse, so frequent scrapes don't
+// allocate a body-sized buffer each. Buffers that grew past
+// JSON_BUFFER_MAX_BYTES are dropped instead of being kept around.
+var (
+	responseBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	maxPooledBuffer = getenvInt("JSON_BUFFER_MAX_BYTES", 4<<20)
+)
+
+// writeJSONResponse writes v as the whole response body. It is buffered so
+// the response has a Content-Length instead of being chunked; NDJSON and the
+// SSE stream write piecewise and stay chunked.
+func writeJSONResponse(w http.ResponseWriter, status int, v interface{}, o jsonOpts) error {
+	buf := responseBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			buf.Reset()
+			responseBuffers.Put(buf)
+		}
+	}()
+	if err := writeJSON(buf, v, o); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// writeJSON encodes v to w. With the default options it streams straight
+// from the encoder; otherwise the keys are rewritten after marshaling. The
+// struct tags stay snake_case either way.
+func writeJSON(w io.Writer, v interface{}, o jsonOpts) error {
+	enc := json.NewEncoder(w)
+	if o.pretty {
+		enc.SetIndent("", "  ")
+	}
+	if !o.rewrites() {
+		return enc.Encode(v)
+	}
+	data, err := marshalJSON(v, o)
+	if err != nil {
+		return err
+	}
+	if o.pretty {
+		return enc.Encode(json.RawMessage(data)) // the encoder indents raw JSON too
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// marshalJSON is json.Marshal with the output options applied.
+func marshalJSON(v interface{}, o jsonOpts) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || !o.rewrites() {
+		return data, err
+	}
+	return rewriteKeys(data, o.key)
+}
+
+// rewriteKeys passes every object key in data through fn, which returns the
+// new name or false to drop the key and its value. depth is the number of
+// enclosing objects (1 for the fields of a top-level object or of the
+// objects in a top-level array). Field order and values stay untouched.
+func rewriteKeys(data []byte, fn func(key string, depth int) (string, bool)) ([]byte, error) {
+	type frame struct {
+		obj bool
+		n   int // tokens written so far (keys + values for objects)
+	}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	var out strings.Builder
+	var stack []frame
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		closing := tok == json.Delim('}') || tok == json.Delim(']')
+		if len(stack) > 0 && !closing {
+			top := &stack[len(stack)-1]
+			if top.obj && top.n%2 == 0 {
+				name, keep := fn(tok.(string), depth)
+				if !keep {
+					if err := skipValue(dec); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				if top.n > 0 {
+					out.WriteByte(',')
+				}
+				enc, _ := json.Marshal(name)
+				out.Write(enc)
+				out.WriteByte(':')
+				top.n++
+				continue
+			}
+			if !top.obj && top.n > 0 {
+				out.WriteByte(',')
+			}
+			top.n++
+		}
+		switch t := tok.(type) {
+		case json.Delim:
+			out.WriteRune(rune(t))
+			switch t {
+			case '{':
+				stack = append(stack, frame{obj: true})
+				depth++
+			case '[':
+				stack = append(stack, frame{})
+			case '}':
+				stack = stack[:len(stack)-1]
+				depth--
+			case ']':
+				stack = stack[:len(stack)-1]
+			}
+		case json.Number:
+			out.WriteString(t.String())
+		default: // string, bool or nil
+			enc, _ := json.Marshal(t)
+			out.Write(enc)
+		}
+	}
+	return []byte(out.String()), nil
+}
+
+// skipValue consumes the next value (scalar, object or array) from dec.
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+func snakeToCamel(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func useJSONCase(t *testing.T, c string) {
+	t.Helper()
+	old := jsonCase
+	jsonCase = c
+	t.Cleanup(func() { jsonCase = old })
+}
+
+func TestJSONCaseCamel(t *testing.T) {
+	useJSONCase(t, "camel")
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, ProfileStats{Name: "a", RawBytes: 1}, jsonOpts{profileDepth: 1}); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got["rawBytes"]; !ok {
+		t.Errorf("no rawBytes in %s", buf.String())
+	}
+	if _, ok := got["raw_bytes"]; ok {
+		t.Errorf("raw_bytes not rewritten in %s", buf.String())
+	}
+}
+
+// TestConfigKeysKeepTheirCase checks that /config shows the variable names
+// as they are spelled, whatever JSON_CASE says.
+func TestConfigKeysKeepTheirCase(t *testing.T) {
+	for _, c := range []string{"snake", "camel"} {
+		useJSONCase(t, c)
+		rec := httptest.NewRecorder()
+		configHandler(rec, httptest.NewRequest("GET", "/config", nil))
+		var got map[string]json.RawMessage
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		for _, k := range []string{"CACHE_SECONDS", "JSON_CASE", "REDIS_URL", "cache_ttl_seconds"} {
+			if _, ok := got[k]; !ok {
+				t.Errorf("JSON_CASE=%s: no %s in /config", c, k)
+			}
+		}
+	}
+}
+
+// discardWriter is a ResponseWriter that throws the body away, so the
+// benchmark only counts what writeJSONResponse allocates.
+type discardWriter struct{ h http.Header }
+
+func (w *discardWriter) Header() http.Header         { return w.h }
+func (w *discardWriter) Write(b []byte) (int, error) { return io.Discard.Write(b) }
+func (w *discardWriter) WriteHeader(int)             {}
+
+func BenchmarkWriteJSONResponse(b *testing.B) {
+	profiles := make([]ProfileStats, 200)
+	for i := range profiles {
+		p := &profiles[i]
+		p.Name = fmt.Sprintf("host%03d", i)
+		p.RawBytes, p.RawHuman = int64(i)<<30, human(int64(i)<<30)
+		p.Warnings = []string{"raw-data: partial"}
+		for j := range 5 {
+			p.Paths = append(p.Paths, PathSnapshot{Path: fmt.Sprintf("/srv/data/%d", j)})
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		o    jsonOpts
+	}{
+		{"plain", jsonOpts{profileDepth: 1}},
+		{"fields", jsonOpts{profileDepth: 1, fields: map[string]bool{"name": true, "raw_bytes": true}}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			w := &discardWriter{h: http.Header{}}
+			b.ReportAllocs()
+			for b.Loop() {
+				if err := writeJSONResponse(w, http.StatusOK, profiles, tc.o); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+)
+
+/* ─── lock check ──────────────────────────────────────────────────────────── */
+
+var checkLocksEnabled = os.Getenv("CHECK_LOCKS") == "true"
+
+const maxLocksInspected = 20 // `cat lock` calls per profile and refresh
+
+// lockJSON is the output of `restic cat lock ID --json`.
+type lockJSON struct {
+	Time      string `json:"time"`
+	Exclusive bool   `json:"exclusive"`
+	Hostname  string `json:"hostname"`
+	Username  string `json:"username"`
+	PID       int    `json:"pid"`
+	UID       uint32 `json:"uid"`
+	GID       uint32 `json:"gid"`
+}
+
+// checkLocks counts the repository's locks and reports whether one of them
+// is older than LOCK_STALE_SECONDS, typically left behind by a backup that
+// was killed and needing `restic unlock`.
+func checkLocks(ctx context.Context, dir string) (count int, stale bool, err error) {
+	var ids []string
+	err = runLines(ctx, dir, "list", []string{"locks"}, func(line []byte) {
+		ids = append(ids, strings.Fields(string(line))...)
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	for i, id := range ids {
+		if i == maxLocksInspected {
+			break
+		}
+		var l lockJSON
+		if err := runAndParse(ctx, dir, "cat", "", []string{"lock", id}, &l); err != nil {
+			continue // released in the meantime
+		}
+		if t, err := time.Parse(time.RFC3339, l.Time); err == nil && clock().Sub(t) > conf().lockStaleAfter {
+			stale = true
+		}
+	}
+	return len(ids), stale, nil
+}
@@ -2,35 +2,88 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"io"
 	"math"
+	"math/bits"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
 const defaultCache = 3600 // 1 h
 
 var (
-	dataRoot     string
-	resticBinary string
-	cacheSeconds int
-	skipStats    bool
-
-	cacheMu    sync.RWMutex
-	cachedAt   time.Time
-	cachedData []ProfileStats
-
-	computeMu   sync.Mutex
-	computing   bool
-	computeCond = sync.NewCond(&computeMu)
+	dataRoot         string
+	resticBinary     string
+	resticBin        string // plain restic, for COMMAND_STYLE=restic and its version
+	commandStyle     string // "resticprofile" or "restic"
+	skipStats        bool
+	snapshotsLimit   int // SNAPSHOTS_LIMIT, 0 = all snapshots
+	ratioPrecision   int // decimals of the human readable ratios, RATIO_PRECISION
+	jsonCase         string
+	concurrency      int           // profiles generated in parallel
+	commandSlots     chan struct{} // COMMAND_CONCURRENCY: restic commands running at once, over all profiles
+	bgRefresh        int           // seconds between background refreshes, 0 = off
+	refreshOnStartup bool          // warm the cache before listening
+	strictConfig     bool
+	statsModes       map[string]bool // optional extra `stats --mode` runs
+	jsonOnly         bool            // stdout is pure JSON, no log lines to skip
+	strictJSON       bool            // reject restic JSON fields we do not map
+	strictGeneration bool            // any failing profile fails the whole refresh
+	oneshot          bool            // print the stats once and exit instead of serving
+	listenAddr       string
+	routePrefix      string // "" or "/something" without trailing slash
+	statsRoute       string // extra path for /stats, e.g. "/api/backups"; "/stats" = none
+	socketMode       os.FileMode
+	syslogAddr       string // SYSLOG_ADDR: also send the log to syslog, see startSyslog
+	syslogTag        string
+
+	acceptedExitCodes map[int]bool // non-zero exit codes treated as success with warning
+
+	cacheMu        sync.RWMutex
+	cachedAt       time.Time
+	cachedTTL      time.Duration
+	cachedData     []ProfileStats // served to clients (groups applied)
+	cachedProfiles []ProfileStats // individual profiles as generated
+
+	errStaleExpired = errors.New("refresh failed and cached data is too old")
+
+	// inflight is the single-flight latch: non-nil while a full refresh
+	// (or refreshProfile) runs and closed when it is done. Guarded by
+	// computeMu.
+	computeMu sync.Mutex
+	inflight  chan struct{}
 )
 
+// Locking rules for the cache:
+//   - computeMu may be held while taking cacheMu, never the other way round.
+//   - Neither lock is held while waiting on inflight or while restic runs.
+//   - Only the goroutine that set inflight may close and clear it, with
+//     releaseLatch.
+
+// clock is the current time for cache expiry, staleness and the relative
+// "x ago" strings. Tests can replace it; durations of restic commands are
+// still measured with time.Now.
+var clock = time.Now
+
 /* ─── JSON models ─────────────────────────────────────────────────────────── */
 
 type restoreJSON struct {
@@ -40,35 +93,118 @@ type restoreJSON struct {
 }
 
 type rawJSON struct {
-	TotalSize            int64   `json:"total_size"`
-	TotalUncompressed    int64   `json:"total_uncompressed_size"`
-	CompressionRatio     float64 `json:"compression_ratio"`
-	CompressionProgress  int64   `json:"compression_progress"`
-	CompressionSavingPct float64 `json:"compression_space_saving"`
-	TotalBlobCount       int64   `json:"total_blob_count"`
-	SnapshotsCount       int64   `json:"snapshots_count"`
+	TotalSize            int64    `json:"total_size"`
+	TotalUncompressed    int64    `json:"total_uncompressed_size"`
+	CompressionRatio     *float64 `json:"compression_ratio"` // nil: repository format 1, no compression
+	CompressionProgress  percent  `json:"compression_progress"`
+	CompressionSavingPct *float64 `json:"compression_space_saving"`
+	TotalBlobCount       int64    `json:"total_blob_count"`
+	SnapshotsCount       int64    `json:"snapshots_count"`
+}
+
+// percent is an integer percentage that also accepts floats (some restic
+// versions emit compression_progress as e.g. 100.0).
+type percent int64
+
+func (p *percent) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var f float64
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	*p = percent(math.Round(f))
+	return nil
+}
+
+type blobsPerFileJSON struct {
+	TotalSize      int64 `json:"total_size"`
+	TotalFileCount int64 `json:"total_file_count"`
+	TotalBlobCount int64 `json:"total_blob_count"`
+	SnapshotsCount int64 `json:"snapshots_count"`
 }
 
 type snapshotEntry struct {
 	Time  string   `json:"time"`  // RFC 3339
 	Paths []string `json:"paths"` // list of source paths
+
+	// not used (yet), mapped so STRICT_JSON accepts them
+	Tree           string          `json:"tree"`
+	Parent         string          `json:"parent"`
+	Hostname       string          `json:"hostname"`
+	Username       string          `json:"username"`
+	UID            uint32          `json:"uid"`
+	GID            uint32          `json:"gid"`
+	Excludes       []string        `json:"excludes"`
+	Tags           []string        `json:"tags"`
+	Original       string          `json:"original"`
+	ProgramVersion string          `json:"program_version"`
+	Summary        json.RawMessage `json:"summary"`
+	ID             string          `json:"id"`
+	ShortID        string          `json:"short_id"`
+}
+
+// snapshotList is the `snapshots --json` output: a bare array, or an object
+// wrapping it as {"snapshots": [...]} as some resticprofile wrappers print.
+type snapshotList []snapshotEntry
+
+func (l *snapshotList) UnmarshalJSON(data []byte) error {
+	// decodeJSON keeps STRICT_JSON for the entries
+	if s := strings.TrimSpace(string(data)); strings.HasPrefix(s, "{") {
+		var wrapped struct {
+			Snapshots []snapshotEntry `json:"snapshots"`
+		}
+		if err := decodeJSON(strings.NewReader(s), &wrapped); err != nil {
+			return err
+		}
+		*l = wrapped.Snapshots
+		return nil
+	}
+	return decodeJSON(strings.NewReader(string(data)), (*[]snapshotEntry)(l))
 }
 
 /* ─── API model ───────────────────────────────────────────────────────────── */
 
 type PathSnapshot struct {
-	Path         string `json:"path"`
-	LastSnapshot string `json:"last_snapshot"` // human readable
+	Path             string `json:"path"`
+	LastSnapshot     string `json:"last_snapshot"` // human readable
+	LastSnapshotUnix int64  `json:"last_snapshot_unix"`
+	LastSnapshotISO  string `json:"last_snapshot_iso"` // RFC 3339, UTC
+}
+
+// HostSnapshots summarises one host's snapshots, see /stats/snapshots.
+type HostSnapshots struct {
+	Host             string `json:"host"`
+	Snapshots        int    `json:"snapshots"`
+	LastSnapshot     string `json:"last_snapshot"` // human readable
+	LastSnapshotUnix int64  `json:"last_snapshot_unix"`
+	LastSnapshotISO  string `json:"last_snapshot_iso"` // RFC 3339, UTC
+}
+
+// BlobsPerFile is the optional `stats --mode blobs-per-file` section.
+type BlobsPerFile struct {
+	Bytes int64  `json:"bytes"`
+	Human string `json:"human"`
+	Files int64  `json:"files"`
+	Blobs int64  `json:"blobs"`
 }
 
 type ProfileStats struct {
 	// Identification
-	Name string `json:"name"`
+	Name        string   `json:"name"`
+	DisplayName string   `json:"display_name,omitempty"` // PROFILE_OVERRIDES name
+	Members     []string `json:"members,omitempty"`      // set on aggregated group rows
+	RepoID      string   `json:"repo_id"`                // changes when the repository is re-initialised
+	RepoVersion int      `json:"repo_version,omitempty"` // repository format, 1 or 2 (compression); 0 = unknown
+	SourceDir   string   `json:"source_dir,omitempty"`   // absolute profile directory, not set on group rows
+	Scope       string   `json:"scope,omitempty"`        // PROFILE_SCOPES filter, e.g. "host:web1"
 
 	// Restore‑size
-	RestoreBytes int64  `json:"restore_bytes"`
-	RestoreHuman string `json:"restore_human"`
-	RestoreFiles int64  `json:"restore_files"`
+	RestoreBytes     int64   `json:"restore_bytes"`
+	RestoreHuman     string  `json:"restore_human"`
+	RestoreFiles     int64   `json:"restore_files"`
+	FilesPerSnapshot float64 `json:"files_per_snapshot,omitempty"` // RestoreFiles / Snapshots, needs restore-size
 
 	// Raw‑data
 	RawBytes               int64   `json:"raw_bytes"`
@@ -79,15 +215,53 @@ type ProfileStats struct {
 	CompressRatioHuman     string  `json:"compression_ratio_human"`
 	CompressionSavingPc    float64 `json:"compression_space_saving"`
 	CompressionSavingHuman string  `json:"compression_space_saving_human"`
+	SavedBytes             int64   `json:"compression_saved_bytes"` // UncompBytes - RawBytes, never negative
+	SavedHuman             string  `json:"compression_saved_human"`
 	CompressionProgPct     int64   `json:"compression_progress"`
 	RawBlobs               int64   `json:"raw_blob_count"`
+	LastMaintenance        int64   `json:"last_maintenance_unix"` // when the repo was last seen shrinking (prune), 0 = not seen
+	SizeTrend              string  `json:"size_trend,omitempty"`  // growing, shrinking, stable or unknown; needs raw-data
+
+	// Optional stats modes (STATS_MODES)
+	BlobsPerFile *BlobsPerFile `json:"blobs_per_file,omitempty"`
+
+	// Locks (CHECK_LOCKS)
+	Locks        int  `json:"locks,omitempty"`
+	HasStaleLock bool `json:"has_stale_lock,omitempty"` // a lock older than LOCK_STALE_SECONDS
 
 	// Snapshot info
-	LastSnapshot string         `json:"last_snapshot"`
-	Paths        []PathSnapshot `json:"paths"`
+	LastSnapshot           string          `json:"last_snapshot"`
+	LastSnapshotUnix       int64           `json:"last_snapshot_unix"`
+	LastSnapshotISO        string          `json:"last_snapshot_iso"`                   // RFC 3339, UTC
+	LastSnapshotID         string          `json:"last_snapshot_id"`                    // short ID
+	LastSnapshotAdded      int64           `json:"last_snapshot_added_bytes,omitempty"` // LAST_DELTA
+	LastSnapshotAddedHuman string          `json:"last_snapshot_added_human,omitempty"`
+	Paths                  []PathSnapshot  `json:"paths"`
+	PathsOmitted           bool            `json:"paths_omitted,omitempty"`   // dropped under memory pressure
+	CoveredPaths           int             `json:"covered_paths"`             // distinct source paths in the snapshots
+	OldestPathSnapshotUnix int64           `json:"oldest_path_snapshot_unix"` // the path backed up longest ago
+	Hosts                  []HostSnapshots `json:"-"`                         // served by /stats/snapshots?group_by=host
+	Activity               []snapshotEntry `json:"-"`                         // time, host and paths of each snapshot, for ?since=
+	SnapshotsPerDay        float64         `json:"snapshots_per_day"`
+	LargestGapSeconds      int64           `json:"largest_gap_seconds"` // longest time between two snapshots
+
+	// Backup schedule from the resticprofile config (0 = unknown)
+	ExpectedIntervalSeconds int64 `json:"expected_interval_seconds"`
+
+	// Health rules (HEALTH_*) and staleness, evaluated when serving
+	Healthy       bool     `json:"healthy"`
+	HealthReasons []string `json:"health_reasons,omitempty"` // why not
+	IsStale       bool     `json:"is_stale"`                 // see staleFor, ?stale_threshold= overrides
+
+	// OFFLINE_FALLBACK: unreachable, the values are from the last good refresh
+	Offline      bool  `json:"offline,omitempty"`
+	OfflineSince int64 `json:"offline_since_unix,omitempty"` // first failed refresh
 
 	// Common
-	Snapshots int64 `json:"snapshots"`
+	Snapshots         int64    `json:"snapshots"`
+	RefreshDurationMs int64    `json:"refresh_duration_ms"` // wall-clock time of all restic commands
+	Warnings          []string `json:"warnings,omitempty"`  // e.g. partial results
+	Inactive          bool     `json:"inactive,omitempty"`  // ACTIVE_WITHIN: no recent snapshot, sizes kept from an earlier refresh
 }
 
 /* ─── init ────────────────────────────────────────────────────────────────── */
@@ -95,167 +269,938 @@ type ProfileStats struct {
 func init() {
 	dataRoot = getenvOr("DATA_ROOT", "/data")
 	resticBinary = getenvOr("RESTICPROFILE_BINARY", "/usr/local/bin/resticprofile")
-	cacheSeconds = getCacheSeconds()
+	resticBin = getenvOr("RESTIC_BINARY", "restic")
+	commandStyle = getenvOr("COMMAND_STYLE", "resticprofile")
+	current = loadSettings() // the settings SIGHUP can reload
+	cachedTTL = time.Duration(current.cacheSeconds) * time.Second
 	skipStats = os.Getenv("SKIP_STATS") == "true"
+	snapshotsLimit = getenvInt("SNAPSHOTS_LIMIT", 0)
+	ratioPrecision = getRatioPrecision()
+	jsonCase = getenvOr("JSON_CASE", "snake")
+	concurrency = getenvInt("CONCURRENCY", 1)
+	commandSlots = make(chan struct{}, getenvInt("COMMAND_CONCURRENCY", concurrency))
+	bgRefresh = getenvInt("BACKGROUND_REFRESH", 0)
+	refreshOnStartup = os.Getenv("REFRESH_ON_STARTUP") == "true"
+	strictConfig = os.Getenv("STRICT_CONFIG") == "true"
+	statsModes = getenvSet("STATS_MODES")
+	jsonOnly = os.Getenv("RESTIC_JSON_ONLY") == "true"
+	strictJSON = os.Getenv("STRICT_JSON") == "true"
+	strictGeneration = os.Getenv("STRICT_GENERATION") == "true"
+	oneshot = os.Getenv("ONESHOT") == "true"
+	listenAddr = getenvOr("LISTEN_ADDR", ":8080")
+	routePrefix = getRoutePrefix()
+	statsRoute = getStatsRoute()
+	socketMode = getSocketMode()
+	syslogAddr = os.Getenv("SYSLOG_ADDR")
+	syslogTag = getenvOr("SYSLOG_TAG", "resticprofile-stat-server")
+	acceptedExitCodes = getExitCodes(getenvOr("ACCEPTED_EXIT_CODES", "3"))
 }
 
 /* ─── main ────────────────────────────────────────────────────────────────── */
 
 func main() {
+	stdout := os.Stdout
+	if oneshot {
+		os.Stdout = os.Stderr // keep stdout for the JSON, see runOnce
+	}
+	stopSyslog := func() {}
+	exit := func(code int) {
+		stopSyslog()
+		os.Exit(code)
+	}
+	if syslogAddr != "" {
+		stop, err := startSyslog(syslogAddr)
+		if err != nil {
+			fmt.Println("Syslog:", err)
+			os.Exit(1)
+		}
+		stopSyslog = stop
+		fmt.Printf("Logging to syslog at %s\n", syslogAddr)
+	}
+	fmt.Printf("resticprofile-stat-server %s\n", version)
 	fmt.Printf("Data root: %s\n", dataRoot)
-	fmt.Printf("Resticprofile binary: %s\n", resticBinary)
-	fmt.Printf("Cache TTL: %ds\n", cacheSeconds)
+	fmt.Printf("Source mode: %s\n", sourceMode)
+	fmt.Printf("Command style: %s\n", commandStyle)
+	if commandStyle == "restic" {
+		fmt.Printf("Restic binary: %s\n", resticBin)
+	} else {
+		fmt.Printf("Resticprofile binary: %s\n", resticBinary)
+	}
+	fmt.Printf("Cache TTL: %ds\n", conf().cacheSeconds)
 	fmt.Printf("Skip stats: %v\n", skipStats)
+	fmt.Printf("Disabled stats: %s\n", strings.Join(setKeys(conf().disabledStats), ","))
+	fmt.Printf("JSON case: %s\n", jsonCase)
+	fmt.Printf("Concurrency: %d (%d commands)\n", concurrency, cap(commandSlots))
+	fmt.Printf("Groups: %d (mode %s)\n", len(groups), groupMode)
+	if err := validateConfig(); err != nil {
+		fmt.Println("Invalid configuration:", err)
+		exit(1)
+	}
+	if configFile != "" {
+		if err := reloadConfig(); err != nil {
+			fmt.Println("Invalid configuration:", err)
+			exit(1)
+		}
+		fmt.Printf("Config file: %s (cache TTL %ds)\n", configFile, conf().cacheSeconds)
+	}
+	if err := loadLastGood(); err != nil {
+		fmt.Printf("WARNING: reading OFFLINE_CACHE_FILE: %v\n", err)
+	}
+	if oneshot {
+		exit(runOnce(stdout))
+	}
+	go reloadOnHUP()
+	fmt.Printf("Background refresh: %ds\n", bgRefresh)
+
+	if refreshOnStartup {
+		warmup()
+	}
+	if watchMode {
+		startWatcher()
+	}
+	if bgRefresh > 0 {
+		interval := time.Duration(bgRefresh) * time.Second
+		go func() {
+			if refreshOnStartup {
+				// the cache was just filled; counted from the start like the rest
+				time.Sleep(interval - time.Duration(lastRefreshMs.Load())*time.Millisecond)
+			}
+			backgroundRefresh(interval)
+		}()
+	}
 
-	http.HandleFunc("/stats", statsHandler)
+	ln, cleanup, err := listen(listenAddr)
+	if err != nil {
+		fmt.Println(err)
+		exit(1)
+	}
+	srv := &http.Server{Handler: withRequestID(recoverPanics(limitRequests(routes(routePrefix), maxRequests)))}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}()
 
-	fmt.Println("Listening on :8080 🚀")
-	fmt.Println(http.ListenAndServe(":8080", nil))
+	if statsRoute != "/stats" {
+		fmt.Printf("Stats also served at %s%s\n", routePrefix, statsRoute)
+	}
+	fmt.Printf("Listening on %s%s 🚀\n", listenAddr, routePrefix)
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		fmt.Println(err)
+	}
+	cleanup()
+	stopSyslog()
+}
+
+// warmup is REFRESH_ON_STARTUP: fill the cache before the server starts
+// listening, so even the first request is answered from it.
+func warmup() {
+	start := time.Now()
+	fmt.Println("Warming up cache...")
+	res, err := getStats(context.Background())
+	if err != nil {
+		fmt.Printf("Warmup failed after %s: %v\n", time.Since(start).Round(time.Millisecond), err)
+		return
+	}
+	fmt.Printf("Warmup done: %d profiles in %s\n", len(res), time.Since(start).Round(time.Millisecond))
+}
+
+// runOnce is ONESHOT mode: generate the stats once, print them as JSON to
+// out and return the exit code. main has pointed os.Stdout at stderr, so our
+// logs and restic's output do not end up in the JSON.
+func runOnce(out io.Writer) int {
+	stats, err := generateStats(context.Background(), nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := writeJSON(out, withHealth(applyGroups(stats)), jsonOpts{profileDepth: 1}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// routes registers all endpoints, mounted under prefix (e.g. "/backup-stats")
+// when one is configured.
+func routes(prefix string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", statsHandler)
+	mux.HandleFunc("/stats/v2", statsV2Handler)
+	mux.HandleFunc("/stats/refresh", refreshHandler)
+	mux.HandleFunc("/stats/failures", failuresHandler)
+	mux.HandleFunc("/stats/disabled", disabledHandler)
+	mux.HandleFunc("/stats/stream", streamHandler)
+	mux.HandleFunc("/stats/snapshots", snapshotsHandler)
+	mux.HandleFunc("/stats/progress", progressHandler)
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/config", configHandler)
+	if statsRoute != "/stats" {
+		mux.HandleFunc(statsRoute, statsHandler)
+	}
+	if enableExpvar {
+		mux.Handle("/debug/vars", expvar.Handler())
+	}
+	if enableUI {
+		mux.HandleFunc("/{$}", uiHandler)
+	}
+	if prefix == "" {
+		return mux
+	}
+	root := http.NewServeMux()
+	root.Handle(prefix+"/", http.StripPrefix(prefix, mux))
+	return root
+}
+
+// listen opens the listener for LISTEN_ADDR: "unix:/path/to.sock" for a Unix
+// domain socket, anything else is a TCP address (e.g. ":8080", "[::1]:8080").
+// The returned cleanup removes the socket file again.
+func listen(addr string) (net.Listener, func(), error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		ln, err := net.Listen("tcp", addr)
+		return ln, func() {}, err
+	}
+	// a previous run that was killed leaves its socket behind
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		ln.Close()
+		return nil, nil, err
+	}
+	return ln, func() { _ = os.Remove(path) }, nil
+}
+
+// validateConfig checks settings that are fine on their own but confusing in
+// combination. Problems are only warnings unless STRICT_CONFIG=true.
+func validateConfig() error {
+	switch statsRoute {
+	case "/stats/v2", "/stats/refresh", "/stats/failures", "/stats/disabled", "/stats/stream", "/stats/snapshots", "/stats/progress", "/metrics", "/healthz", "/config":
+		return fmt.Errorf("STATS_ROUTE %s is taken by another endpoint", statsRoute)
+	}
+	if sourceMode != "commands" && sourceMode != "files" {
+		return fmt.Errorf("unknown SOURCE_MODE %q, want commands or files", sourceMode)
+	}
+	if _, ok := byteStyles[humanStyle]; !ok {
+		return fmt.Errorf("unknown HUMANIZE_STYLE %q, want ours or restic", humanStyle)
+	}
+	// without CACHE_SECONDS the TTL comes from the schedules, which can be
+	// far shorter than the default
+	cacheSeconds, ttlName := conf().cacheSeconds, "CACHE_SECONDS"
+	if !conf().cacheSecondsSet {
+		cacheSeconds, ttlName = int(diskScheduleTTL().Seconds()), "the cache TTL derived from the backup schedules"
+	}
+	if bgRefresh > cacheSeconds {
+		msg := fmt.Sprintf("BACKGROUND_REFRESH (%ds) is longer than %s (%ds), requests would trigger refreshes in between", bgRefresh, ttlName, cacheSeconds)
+		if strictConfig {
+			return fmt.Errorf("%s", msg)
+		}
+		fmt.Printf("WARNING: %s; clamping BACKGROUND_REFRESH to %ds\n", msg, cacheSeconds)
+		bgRefresh = cacheSeconds
+	}
+	return nil
 }
 
 /* ─── HTTP handler & caching ──────────────────────────────────────────────── */
 
 func statsHandler(w http.ResponseWriter, r *http.Request) {
-	res, err := getStats()
+	serveStats(w, r, r.URL.Query().Get("version"))
+}
+
+// serveStats writes the (filtered) cached stats in the requested format and
+// response version ("" means 1).
+func serveStats(w http.ResponseWriter, r *http.Request, version string) {
+	if version != "" && version != "1" && version != "2" {
+		http.Error(w, "unknown version "+version, http.StatusBadRequest)
+		return
+	}
+	res, err := getStats(r.Context())
+	if err != nil {
+		statsError(w, err)
+		return
+	}
+	var threshold time.Duration // 0: per profile, see staleThreshold
+	if v := cmp.Or(r.URL.Query().Get("stale_threshold"), r.URL.Query().Get("threshold")); v != "" {
+		s, err := strconv.Atoi(v)
+		if err != nil || s < 0 {
+			http.Error(w, "invalid threshold", http.StatusBadRequest)
+			return
+		}
+		threshold = time.Duration(s) * time.Second
+	}
+	if r.URL.Query().Get("stale_only") == "true" {
+		res = filterStale(res, threshold)
+	}
+	if name := r.URL.Query().Get("profile"); name != "" {
+		res = filterNames(res, func(n string) bool { return n == name })
+	}
+	if rel := r.URL.Query().Get("path"); rel != "" {
+		dir, err := profilePath(rel)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res = filterPath(res, dir)
+	}
+	if m := r.URL.Query().Get("match"); m != "" {
+		re, err := anchoredRegexp(m)
+		if err != nil {
+			http.Error(w, "invalid match: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		res = filterNames(res, re.MatchString)
+	}
+	res = withHealth(res)
+	if threshold > 0 {
+		for i := range res { // withHealth copied them
+			res[i].IsStale = staleFor(res[i], threshold)
+		}
+	}
+	if underMemoryPressure() {
+		res = dropPaths(res)
+	}
+	switch f := responseFormat(r); f {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		if version == "2" {
+			o := jsonOptions(r)
+			o.profileDepth = 2 // {"api_version": 2, "profiles": [...]}
+			_ = writeJSONResponse(w, http.StatusOK, statsV2(res), o)
+		} else {
+			_ = writeJSONResponse(w, http.StatusOK, res, jsonOptions(r))
+		}
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		writeNDJSON(w, res, version == "2", jsonOptions(r))
+	case "influx":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writeInflux(w, res, cacheTime())
+	default:
+		http.Error(w, "unknown format "+f, http.StatusBadRequest)
+	}
+}
+
+// statsError reports a getStats() failure; stale data past MAX_STALE_SECONDS
+// is an outage (503) rather than a server bug (500).
+func statsError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if errors.Is(err, errStaleExpired) {
+		code = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// refreshHandler recomputes one profile on demand, e.g. from a backup
+// job's post-hook: POST /stats/refresh?profile=NAME
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("profile")
+	dir, _, _ := strings.Cut(name, "@") // scoped rows are dir@value
+	if _, err := profilePath(dir); err != nil || dir != filepath.Clean(dir) {
+		http.Error(w, "invalid profile", http.StatusBadRequest)
+		return
+	}
+	t, ok := targetByName(name)
+	if fi, err := os.Stat(t.path()); !ok || err != nil || !fi.IsDir() {
+		http.Error(w, "profile not found", http.StatusNotFound)
+		return
+	}
+	if isDisabled(t.path()) {
+		http.Error(w, "profile is disabled", http.StatusConflict)
+		return
+	}
+	p, err := refreshProfile(r.Context(), t)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(res)
+	_ = writeJSONResponse(w, http.StatusOK, p, jsonOptions(r))
 }
 
-func getStats() ([]ProfileStats, error) {
-	// quick cache check
-	cacheMu.RLock()
-	fmt.Println("Cache hit, checking if still valid", time.Since(cachedAt), "since last update", time.Duration(cacheSeconds)*time.Second, "cache seconds")
-	if time.Since(cachedAt) < time.Duration(cacheSeconds)*time.Second && cachedData != nil {
-		defer cacheMu.RUnlock()
-		return cachedData, nil
+func getStats(ctx context.Context) ([]ProfileStats, error) {
+	if data, ok := freshCache(ctx); ok {
+		cacheHits.Add(1)
+		return data, nil
 	}
-	cacheMu.RUnlock()
-
 	// ensure only one generator runs
-	computeMu.Lock()
-	for computing {
-		computeCond.Wait()
+	for {
+		computeMu.Lock()
+		if running := inflight; running != nil {
+			computeMu.Unlock()
+			<-running
+			continue
+		}
+		// maybe someone else refreshed in the meantime
+		if data, ok := freshCache(ctx); ok {
+			computeMu.Unlock()
+			cacheHits.Add(1)
+			return data, nil
+		}
+		inflight = make(chan struct{})
+		computeMu.Unlock()
+		cacheMisses.Add(1)
+		return runRefresh(ctx)
 	}
-	// maybe someone else refreshed while we waited
+}
+
+// freshCache returns the cached data if it is younger than the cache TTL.
+func freshCache(ctx context.Context) ([]ProfileStats, bool) {
 	cacheMu.RLock()
-	fmt.Println("Cache hit 2, checking if still valid", time.Since(cachedAt), "since last update", time.Duration(cacheSeconds)*time.Second, "cache seconds")
-	if time.Since(cachedAt) < time.Duration(cacheSeconds)*time.Second && cachedData != nil {
-		cacheMu.RUnlock()
+	defer cacheMu.RUnlock()
+	logf(ctx, "Cache hit, checking if still valid %s since last update %s cache seconds\n", clock().Sub(cachedAt), cachedTTL)
+	if clock().Sub(cachedAt) < cachedTTL && cachedData != nil {
+		return cachedData, true
+	}
+	return nil, false
+}
+
+// backgroundRefresh regenerates the cache every interval so requests never
+// have to wait for a cold refresh. The interval runs from the start of a
+// refresh: the cache is stamped when a refresh ends, so with an interval of
+// exactly the TTL the next refresh is already running when it expires.
+func backgroundRefresh(interval time.Duration) {
+	for {
+		computeMu.Lock()
+		if running := inflight; running != nil {
+			computeMu.Unlock()
+			<-running
+			continue
+		}
+		inflight = make(chan struct{})
 		computeMu.Unlock()
-		return cachedData, nil
+		start := time.Now()
+		if err := safely("background refresh", func() error {
+			_, err := runRefresh(context.Background())
+			return err
+		}); err != nil {
+			fmt.Printf("Background refresh failed: %v\n", err)
+		}
+		time.Sleep(interval - time.Since(start)) // right away if it took longer
 	}
-	cacheMu.RUnlock()
+}
 
-	computing = true
-	computeMu.Unlock()
+// runRefresh generates fresh stats and stores them in the cache. The caller
+// must have set inflight; runRefresh releases it when done.
+func runRefresh(ctx context.Context) (stats []ProfileStats, err error) {
+	// deferred so a panic cannot leave the latch or cacheMu held
+	defer releaseLatch()
 
-	stats, err := generateStats()
+	// a panic outside the per-profile workers (grouping, scopes, the shared
+	// cache) fails this refresh like any error instead of the process, which
+	// matters for the background refresh where nothing else would recover it
+	var at time.Time
+	err = safely("refresh", func() (err error) {
+		if redisURL != "" {
+			stats, at, err = sharedGenerate(ctx)
+		} else {
+			stats, err = generateStats(ctx, liveRefresh.publish)
+			at = clock()
+		}
+		return err
+	})
 
 	cacheMu.Lock()
+	defer cacheMu.Unlock()
 	if err != nil {
-		fmt.Printf("DEBUG: generateStats() returned an error: %v. CACHE WILL NOT BE UPDATED.", err)
-		fmt.Printf("Error generating stats: %v\n", err)
+		logf(ctx, "DEBUG: generateStats() returned an error: %v. CACHE WILL NOT BE UPDATED.", err)
+		logf(ctx, "Error generating stats: %v\n", err)
+		if opts := conf(); opts.serveStale && cachedData != nil {
+			age := clock().Sub(cachedAt)
+			if opts.maxStale > 0 && age > time.Duration(opts.maxStale)*time.Second {
+				err = fmt.Errorf("%w (%s): %v", errStaleExpired, age.Round(time.Second), err)
+			} else {
+				logf(ctx, "Serving stale data from %s\n", cachedAt.Format(time.RFC3339))
+				stats, err = cachedData, nil
+			}
+		}
 	} else {
-		fmt.Println("DEBUG: generateStats() succeeded (err is nil). PROCEEDING TO UPDATE CACHE.")
-		cachedData = stats
+		logf(ctx, "DEBUG: generateStats() succeeded (err is nil). PROCEEDING TO UPDATE CACHE.\n")
+		cachedProfiles = stats
+		cachedData = applyGroups(stats)
+		if !conf().cacheSecondsSet {
+			cachedTTL = scheduleCacheTTL(stats)
+			// schedules changed since startup: keep the TTL at least at the
+			// background interval so requests do not refresh in between
+			if bg := time.Duration(bgRefresh) * time.Second; cachedTTL < bg {
+				logf(ctx, "WARNING: cache TTL derived from the backup schedules (%s) is shorter than BACKGROUND_REFRESH, using %s\n", cachedTTL, bg)
+				cachedTTL = bg
+			}
+		}
+		stats = cachedData
+		if stdoutMetrics {
+			printMetricsLine(stats)
+		}
 		originalCachedAt := cachedAt
-		cachedAt = time.Now()
-		fmt.Printf("DEBUG: CACHE UPDATED. Old cachedAt for this goroutine: %s, New cachedAt: %s. Time since new update: %s", originalCachedAt.Format(time.RFC3339Nano), cachedAt.Format(time.RFC3339Nano), time.Since(cachedAt))
+		cachedAt = at
+		logf(ctx, "DEBUG: CACHE UPDATED. Old cachedAt for this goroutine: %s, New cachedAt: %s. Time since new update: %s", originalCachedAt.Format(time.RFC3339Nano), cachedAt.Format(time.RFC3339Nano), clock().Sub(cachedAt))
 	}
-	cacheMu.Unlock()
+	return stats, err
+}
 
+// releaseLatch closes and clears inflight, waking everyone waiting on it.
+func releaseLatch() {
 	computeMu.Lock()
-	computing = false
-	computeCond.Broadcast()
+	close(inflight)
+	inflight = nil
 	computeMu.Unlock()
+}
 
-	return stats, err
+// cacheTime returns when the cached data was generated.
+func cacheTime() time.Time {
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	return cachedAt
 }
 
 /* ─── stats generation ────────────────────────────────────────────────────── */
 
-func generateStats() ([]ProfileStats, error) {
-	entries, err := os.ReadDir(dataRoot)
+// generateStats collects all profiles, up to CONCURRENCY at a time, and
+// hands each successful one to onProfile as soon as it is done.
+//
+// Concurrency notes: every collectProfile call only touches its own locals
+// (the restic JSON structs and summariseSnapshots' pathMap), and writes its
+// result into its own slot of results, so workers share nothing but the job
+// channel. Anything that combines profiles (groups, totals) must run on the
+// returned slice after all workers are done, never inside a worker.
+func generateStats(ctx context.Context, onProfile func(ProfileStats)) ([]ProfileStats, error) {
+	start := time.Now()
+	dirs, err := listProfiles()
 	if err != nil {
 		return nil, err
 	}
+	targets := expandScopes(dirs)
+	logf(ctx, "Refreshing %d profiles\n", len(targets))
+	names := make([]string, len(targets))
+	for i, t := range targets {
+		names[i] = t.Name
+	}
+	progress.begin(names)
+	defer progress.end()
+
+	type result struct {
+		p   ProfileStats
+		err error
+	}
+	results := make([]result, len(names))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(1, concurrency); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				progress.set(names[i], progressRunning)
+				var p ProfileStats
+				err := safely("profile "+names[i], func() (err error) {
+					p, err = collectProfile(ctx, targets[i])
+					return err
+				})
+				recordResult(names[i], err)
+				if err != nil {
+					logf(ctx, "%v\n", err)
+					if prev, ok := offlineStats(ctx, targets[i]); ok {
+						p, err = prev, nil
+					}
+				} else {
+					rememberGood(p)
+				}
+				if err != nil {
+					progress.set(names[i], progressFailed)
+					results[i] = result{p, err}
+					continue
+				}
+				progress.set(names[i], progressDone)
+				if onProfile != nil {
+					onProfile(p)
+				}
+				results[i] = result{p, err}
+			}
+		}()
+	}
+	for i := range names {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	pruneFailures(names)
+	if err := saveLastGood(); err != nil {
+		logf(ctx, "Saving OFFLINE_CACHE_FILE failed: %v\n", err)
+	}
+
+	// keep directory order regardless of completion order
 	var stats []ProfileStats
-	for _, e := range entries {
-		if !e.IsDir() {
+	var errs []error
+	for _, r := range results {
+		if r.err != nil {
+			errs = append(errs, r.err)
 			continue
 		}
-		name := e.Name()
-		dirPath := filepath.Join(dataRoot, name)
-
-		// disable restore-size for now as it is very slow
-		// restore‑size
-		// var restore restoreJSON
-		// if err := runAndParse(dirPath, "stats", "restore-size", &restore); err != nil {
-		// 	fmt.Printf("restore-size for %s: %v", dirPath, err)
-		// 	continue
-		// }
-
-		var restore restoreJSON
-		restore.TotalSize = 0
-		restore.TotalFileCount = 0
-		restore.SnapshotsCount = 0
-
-		var raw rawJSON
-		if !skipStats {
-			// raw‑data (slow)
-			if err := runAndParse(dirPath, "stats", "raw-data", nil, &raw); err != nil {
-				fmt.Printf("raw-data for %s: %v\n", dirPath, err)
-				continue
-			}
+		stats = append(stats, r.p)
+	}
+	profilesTotal.Store(int64(len(names)))
+	profilesOK.Store(int64(len(stats)))
+	profilesFailed.Store(int64(len(errs)))
+	lastRefreshMs.Store(time.Since(start).Milliseconds())
+	logf(ctx, "Refreshed %d profiles (%d failed) in %s\n", len(names), len(errs), time.Since(start).Round(time.Millisecond))
+	if strictGeneration && len(errs) > 0 {
+		return nil, fmt.Errorf("%d of %d profiles failed: %w", len(errs), len(names), errors.Join(errs...))
+	}
+	return stats, nil
+}
+
+// collectProfile runs the restic commands for a single profile directory.
+func collectProfile(ctx context.Context, t profileTarget) (ProfileStats, error) {
+	start := time.Now()
+	name, dirPath := t.Name, t.path()
+
+	// budget is shared by all commands of the profile; cancelling it kills
+	// whatever is still running. It must not end with the request that
+	// happened to trigger the refresh, other callers wait for the result.
+	opts := conf()
+	override := opts.overrides[t.Dir]
+	budget, cancel := context.WithoutCancel(ctx), context.CancelFunc(func() {})
+	if limit := cmp.Or(override.Timeout, opts.profileTimeout); limit > 0 {
+		budget, cancel = context.WithTimeoutCause(budget, limit, fmt.Errorf("%w after %s", errProfileTimeout, limit))
+	}
+	defer cancel()
+
+	// run wraps runAndParse, turning accepted exit codes into warnings
+	var warningsMu sync.Mutex
+	var warnings []string
+	addWarnings := func(w ...string) {
+		warningsMu.Lock()
+		warnings = append(warnings, w...)
+		warningsMu.Unlock()
+	}
+	run := func(cmdName, mode string, extraArgs []string, v interface{}) error {
+		args := append(append([]string(nil), extraArgs...), t.Args...) // --host/--tag scope
+		args = append(args, override.Args...)
+		err := runAndParse(budget, dirPath, cmdName, mode, args, v)
+		var pe *partialError
+		if errors.As(err, &pe) {
+			logf(ctx, "%s for %s: %v\n", commandKey(cmdName, mode), dirPath, pe)
+			addWarnings(fmt.Sprintf("%s: %v", commandKey(cmdName, mode), pe))
+			return nil
 		}
+		return err
+	}
 
-		// snapshots (use --latest 1 when skipping stats for faster response)
-		var snaps []snapshotEntry
-		var latestArg []string
-		if skipStats {
-			latestArg = []string{"--latest", "1"}
+	// The commands are independent and run concurrently; COMMAND_CONCURRENCY
+	// (enforced in runAndParse) decides how many actually hit the repository
+	// at once. Each one writes only its own variables.
+	var wg sync.WaitGroup
+	var restoreErr, rawErr, snapsErr error
+	var panicErr error // set when one of the commands panicked
+	var panicMu sync.Mutex
+	goRun := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := safely("profile "+name, func() error { f(); return nil }); err != nil {
+				panicMu.Lock()
+				panicErr = err
+				panicMu.Unlock()
+			}
+		}()
+	}
+
+	// snapshots (use --latest 1 when skipping stats for faster response,
+	// or --latest SNAPSHOTS_LIMIT to bound the work on huge repositories)
+	var snaps snapshotList
+	var latestArg []string
+	if skipStats {
+		latestArg = []string{"--latest", "1"}
+	} else if snapshotsLimit > 0 {
+		latestArg = []string{"--latest", strconv.Itoa(snapshotsLimit)}
+	}
+	goRun(func() {
+		if err := run("snapshots", "", latestArg, &snaps); err != nil {
+			snapsErr = &commandError{"snapshots", dirPath, err}
 		}
-		if err := runAndParse(dirPath, "snapshots", "", latestArg, &snaps); err != nil {
-			fmt.Printf("snapshots for %s: %v\n", dirPath, err)
-			continue
+	})
+
+	// with ACTIVE_WITHIN the snapshots decide whether the slow stats are
+	// worth running; without last known values they run anyway
+	var prev ProfileStats
+	inactive := false
+	if activeWithin > 0 && !skipStats {
+		wg.Wait()
+		if snapsErr == nil && panicErr == nil && inactiveSince(snaps) {
+			prev, inactive = lastKnown(name)
 		}
-		lastSnap, pathInfo := summariseSnapshots(snaps)
-
-		stats = append(stats, ProfileStats{
-			Name:                   name,
-			RestoreBytes:           restore.TotalSize,
-			RestoreHuman:           human(bytes(float64(restore.TotalSize))),
-			RestoreFiles:           restore.TotalFileCount,
-			RawBytes:               raw.TotalSize,
-			RawHuman:               human(bytes(float64(raw.TotalSize))),
-			UncompBytes:            raw.TotalUncompressed,
-			UncompHuman:            human(bytes(float64(raw.TotalUncompressed))),
-			CompressRatio:          raw.CompressionRatio,
-			CompressRatioHuman:     fmt.Sprintf("%.2f", raw.CompressionRatio),
-			CompressionSavingPc:    raw.CompressionSavingPct,
-			CompressionSavingHuman: fmt.Sprintf("%.2f%%", raw.CompressionSavingPct),
-			CompressionProgPct:     raw.CompressionProgress,
-			RawBlobs:               raw.TotalBlobCount,
-
-			LastSnapshot: lastSnap,
-			Paths:        pathInfo,
-
-			Snapshots: restore.SnapshotsCount,
+	}
+
+	// restore‑size (very slow, disabled by default via DISABLE_STATS)
+	var restore restoreJSON
+	var haveRestore bool
+	if !skipStats && !inactive && !opts.disabledStats["restore-size"] {
+		goRun(func() {
+			if err := run("stats", "restore-size", nil, &restore); err != nil {
+				restoreErr = &commandError{"restore-size", dirPath, err}
+				return
+			}
+			c := rangeCheck{ctx: ctx, name: name}
+			c.restore(&restore)
+			addWarnings(c.warnings...)
+			haveRestore = true
 		})
 	}
-	return stats, nil
+
+	var raw rawJSON
+	var haveRaw, rawUnsupported bool
+	var lastMaintenance time.Time
+	var sizeTrend string
+	if !skipStats && !inactive && !opts.disabledStats["raw-data"] {
+		// raw‑data (slow)
+		goRun(func() {
+			if err := run("stats", "raw-data", nil, &raw); err != nil {
+				if errors.Is(err, errUnknownMode) { // old restic, keep the rest of the profile
+					logf(ctx, "raw-data for %s (skipped): %v\n", dirPath, err)
+					addWarnings("raw-data: not supported by this restic version, raw fields are empty")
+					rawUnsupported = true
+					return
+				}
+				rawErr = &commandError{"raw-data", dirPath, err}
+				return
+			}
+			c := rangeCheck{ctx: ctx, name: name}
+			c.raw(&raw)
+			addWarnings(c.warnings...)
+			haveRaw = true
+			lastMaintenance = observeMaintenance(name, raw)
+			sizeTrend = recordSize(name, raw.TotalSize)
+		})
+	}
+
+	var id string
+	var repoVersion int
+	goRun(func() { id, repoVersion = repoID(budget, name, dirPath) })
+
+	var locks int
+	var staleLock bool
+	if checkLocksEnabled {
+		goRun(func() {
+			var err error
+			if locks, staleLock, err = checkLocks(budget, dirPath); err != nil {
+				logf(ctx, "lock check for %s (skipped): %v\n", dirPath, err)
+			}
+		})
+	}
+
+	// optional modes never fail the profile, an unsupported mode just
+	// leaves its section out
+	var blobs *BlobsPerFile
+	if statsModes["blobs-per-file"] && !skipStats && !inactive {
+		goRun(func() {
+			var bpf blobsPerFileJSON
+			if err := run("stats", "blobs-per-file", nil, &bpf); err != nil {
+				logf(ctx, "blobs-per-file for %s (skipped): %v\n", dirPath, err)
+				return
+			}
+			blobs = &BlobsPerFile{
+				Bytes: bpf.TotalSize,
+				Human: human(bpf.TotalSize),
+				Files: bpf.TotalFileCount,
+				Blobs: bpf.TotalBlobCount,
+			}
+		})
+	}
+
+	wg.Wait()
+	// report failures in the order the commands used to run in
+	for _, err := range []error{panicErr, restoreErr, rawErr, snapsErr} {
+		if err != nil {
+			return ProfileStats{}, err
+		}
+	}
+	sort.Strings(warnings) // completion order is random
+	summary := summariseSnapshots(snaps)
+	var added int64
+	if lastDelta && summary.LatestEntry.ID != "" {
+		var err error
+		if added, err = latestAdded(budget, dirPath, summary.LatestEntry); err != nil {
+			logf(ctx, "last delta for %s (skipped): %v\n", dirPath, err)
+		}
+	}
+	// restore-size is off by default, so take the count from wherever we have it
+	snapshotCount := restore.SnapshotsCount
+	switch {
+	case haveRestore:
+	case haveRaw:
+		snapshotCount = raw.SnapshotsCount
+	case latestArg == nil:
+		snapshotCount = int64(len(snaps))
+	}
+	ratio, saving := deref(raw.CompressionRatio), deref(raw.CompressionSavingPct)
+	saved := savedBytes(raw.TotalUncompressed, raw.TotalSize)
+	ratioText, savingText, savedText := ratioHuman(ratio), percentHuman(saving), human(saved)
+	rawText, uncompText := human(raw.TotalSize), human(raw.TotalUncompressed)
+	if haveRaw && !compressionSupported(repoVersion, raw) {
+		ratioText, savingText, savedText = compressionUnsupported, compressionUnsupported, compressionUnsupported
+	}
+	if rawUnsupported {
+		rawText, uncompText, ratioText, savingText, savedText = "", "", "", "", ""
+	}
+	var filesPerSnapshot float64
+	if haveRestore {
+		filesPerSnapshot = perSnapshot(restore.TotalFileCount, restore.SnapshotsCount)
+	}
+
+	p := ProfileStats{
+		Name:                   name,
+		DisplayName:            override.DisplayName,
+		Scope:                  t.Scope,
+		RepoID:                 id,
+		RepoVersion:            repoVersion,
+		SourceDir:              absPath(dirPath),
+		RestoreBytes:           restore.TotalSize,
+		RestoreHuman:           human(restore.TotalSize),
+		RestoreFiles:           restore.TotalFileCount,
+		FilesPerSnapshot:       filesPerSnapshot,
+		RawBytes:               raw.TotalSize,
+		RawHuman:               rawText,
+		UncompBytes:            raw.TotalUncompressed,
+		UncompHuman:            uncompText,
+		CompressRatio:          ratio,
+		CompressRatioHuman:     ratioText,
+		CompressionSavingPc:    saving,
+		CompressionSavingHuman: savingText,
+		SavedBytes:             saved,
+		SavedHuman:             savedText,
+		CompressionProgPct:     int64(raw.CompressionProgress),
+		RawBlobs:               raw.TotalBlobCount,
+		LastMaintenance:        unixOrZero(lastMaintenance),
+		SizeTrend:              sizeTrend,
+
+		BlobsPerFile: blobs,
+
+		Locks:        locks,
+		HasStaleLock: staleLock,
+
+		LastSnapshot:           summary.LastSnapshot,
+		LastSnapshotUnix:       unixOrZero(summary.Latest),
+		LastSnapshotISO:        isoOrEmpty(summary.Latest),
+		LastSnapshotID:         summary.LatestID,
+		LastSnapshotAdded:      added,
+		LastSnapshotAddedHuman: addedHuman(added),
+		Paths:                  summary.Paths,
+		CoveredPaths:           len(summary.Paths),
+		OldestPathSnapshotUnix: unixOrZero(summary.OldestPath),
+		Hosts:                  summary.Hosts,
+		Activity:               activity(snaps),
+		SnapshotsPerDay:        summary.PerDay,
+		LargestGapSeconds:      int64(summary.LargestGap.Seconds()),
+
+		ExpectedIntervalSeconds: int64(backupInterval(dirPath).Seconds()),
+
+		Snapshots:         snapshotCount,
+		RefreshDurationMs: time.Since(start).Milliseconds(),
+		Warnings:          warnings,
+		Inactive:          inactive,
+	}
+	if inactive {
+		keepExpensive(&p, prev)
+		if latestArg != nil { // the count came from the skipped commands
+			p.Snapshots = prev.Snapshots
+		}
+		logf(ctx, "%s: no snapshot within ACTIVE_WITHIN, kept the stats of the last refresh\n", name)
+	}
+	return p, nil
+}
+
+// refreshProfile recomputes a single profile and swaps it into the cache
+// without touching the other entries or the cache age. Nothing is cached
+// if no full refresh has happened yet.
+//
+// It holds the single-flight latch like a full refresh: one that is already
+// running is waited for, and none can start before the profile is swapped
+// in, so a full refresh with older results never overwrites it.
+func refreshProfile(ctx context.Context, t profileTarget) (ProfileStats, error) {
+	for {
+		computeMu.Lock()
+		running := inflight
+		if running == nil {
+			inflight = make(chan struct{})
+			computeMu.Unlock()
+			break
+		}
+		computeMu.Unlock()
+		select {
+		case <-running:
+		case <-ctx.Done():
+			return ProfileStats{}, context.Cause(ctx)
+		}
+	}
+	defer releaseLatch()
+
+	name := t.Name
+	p, err := collectProfile(ctx, t)
+	recordResult(name, err)
+	if err != nil {
+		return ProfileStats{}, err
+	}
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if cachedData == nil {
+		return p, nil
+	}
+	// copy, handlers may still be encoding the old slice
+	profiles := make([]ProfileStats, 0, len(cachedProfiles)+1)
+	found := false
+	for _, old := range cachedProfiles {
+		if old.Name == name {
+			old, found = p, true
+		}
+		profiles = append(profiles, old)
+	}
+	if !found {
+		profiles = append(profiles, p)
+	}
+	cachedProfiles = profiles
+	cachedData = applyGroups(profiles)
+	return p, nil
 }
 
 /* ─── helpers ─────────────────────────────────────────────────────────────── */
 
+// anchoredRegexp compiles expr so that it has to match a whole name. expr is
+// parsed on its own first and anchored in its normalised form, so an
+// unbalanced ")" like in "a)|(b" is an error instead of escaping the anchors.
+func anchoredRegexp(expr string) (*regexp.Regexp, error) {
+	parsed, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	return regexp.Compile(`^(?:` + parsed.String() + `)$`)
+}
+
 // runAndParse executes `resticprofile <cmd> [--mode X] [extraArgs...] --json`, streams logs,
-// and unmarshals the first JSON object (or array) into v.
-func runAndParse(dir, cmdName, mode string, extraArgs []string, v interface{}) error {
-	args := []string{cmdName}
+// and unmarshals the first JSON object (or array) into v. With RESTIC_JSON_ONLY
+// it runs with --quiet and the whole stdout is decoded as one value.
+func runAndParse(ctx context.Context, dir, cmdName, mode string, extraArgs []string, v interface{}) error {
+	if sourceMode == "files" {
+		return readRecorded(dir, cmdName, mode, extraArgs, v)
+	}
+	var args []string
+	if jsonOnly {
+		args = append(args, "--quiet") // keeps log output off stdout
+	}
+	args = append(args, cmdName)
 	if mode != "" {
 		args = append(args, "--mode", mode)
 	}
@@ -266,87 +1211,615 @@ func runAndParse(dir, cmdName, mode string, extraArgs []string, v interface{}) e
 
 	args = append(args, "--no-lock") // avoid setting locks during stats
 
-	cmd := exec.Command(resticBinary, args...)
-	cmd.Dir = dir
+	// waiting for a slot does not count towards the timeout, but it does
+	// towards PROFILE_TIMEOUT
+	if err := acquireSlot(ctx); err != nil {
+		return err
+	}
+	defer func() { <-commandSlots }()
+	defer watchSlow(ctx, dir, commandKey(cmdName, mode))()
+
+	timeout := commandTimeout(cmdName, mode)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd, err := resticCommand(ctx, dir, args)
+	if err != nil {
+		return err
+	}
+	cmd.WaitDelay = 5 * time.Second // don't hang on pipes kept open by restic itself
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return err
 	}
-	cmd.Stderr = os.Stderr
+	var stderr stderrTail
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
 	if err := cmd.Start(); err != nil {
 		return err
 	}
 
-	scanner := bufio.NewScanner(stdout)
+	if jsonOnly {
+		out := io.TeeReader(stdout, os.Stdout)
+		if err := decodeJSON(out, v); err != nil {
+			if errors.Is(err, io.EOF) {
+				return unknownMode(noJSON(ctx, cmd, timeout), mode, &stderr)
+			}
+			_, _ = io.Copy(io.Discard, stdout) // restic may still be writing
+			_ = cmd.Wait()
+			return fmt.Errorf("decode %s JSON: %w", cmdName, err)
+		}
+		_, _ = io.Copy(io.Discard, out)
+		return waitCommand(ctx, cmd, timeout)
+	}
+
+	found, err := scanJSON(stdout, os.Stdout, cmdName, v)
+	if err != nil { // bad JSON or bufio.ErrTooLong
+		_, _ = io.Copy(io.Discard, stdout)
+		_ = cmd.Wait()
+		return err
+	}
+	if !found {
+		return unknownMode(noJSON(ctx, cmd, timeout), mode, &stderr)
+	}
+	return waitCommand(ctx, cmd, timeout)
+}
+
+// scanJSON copies r to echo line by line until the first line that starts a
+// JSON object or array, and decodes that line into v.
+func scanJSON(r io.Reader, echo io.Writer, cmdName string, v interface{}) (found bool, err error) {
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Bytes()
-		os.Stdout.Write(line)
-		os.Stdout.Write([]byte{'\n'})
-		if len(line) > 0 && line[0] == '{' || (len(line) > 0 && line[0] == '[') {
-			if err := json.Unmarshal(line, v); err != nil {
-				return fmt.Errorf("decode %s JSON: %w", cmdName, err)
+		echo.Write(line)
+		echo.Write([]byte{'\n'})
+		if len(line) > 0 && (line[0] == '{' || line[0] == '[') {
+			if err := decodeJSON(strings.NewReader(string(line)), v); err != nil {
+				return false, fmt.Errorf("decode %s JSON: %w", cmdName, err)
 			}
-			break
+			return true, nil
 		}
 	}
+	return false, scanner.Err()
+}
+
+var errNoJSON = errors.New("no JSON in output")
+
+// noJSON is runAndParse's result when stdout had no JSON at all: the
+// command's own error if it failed, errNoJSON otherwise, so the profile is
+// marked failed instead of reporting zeros.
+func noJSON(ctx context.Context, cmd *exec.Cmd, timeout time.Duration) error {
+	err := waitCommand(ctx, cmd, timeout)
+	var pe *partialError
+	if err == nil || errors.As(err, &pe) {
+		return errNoJSON
+	}
+	return err
+}
+
+// errUnknownMode means restic does not know the `stats --mode` asked for,
+// e.g. raw-data on very old versions.
+var errUnknownMode = errors.New("mode not supported by this restic version")
+
+// stderrTail keeps the end of a command's stderr, enough to recognise its
+// fatal error message.
+type stderrTail struct{ buf []byte }
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > 4096 {
+		t.buf = append([]byte(nil), t.buf[len(t.buf)-4096:]...)
+	}
+	return len(p), nil
+}
+
+// unknownMode turns the error of a failed `stats --mode X` into
+// errUnknownMode if restic said so ("Fatal: unknown mode ...").
+func unknownMode(err error, mode string, stderr *stderrTail) error {
+	if err != nil && mode != "" && bytes.Contains(stderr.buf, []byte("unknown mode")) {
+		return fmt.Errorf("%w: %s", errUnknownMode, mode)
+	}
+	return err
+}
+
+// runLines runs a restic command without --json (like `list`) or with one
+// JSON message per line (like `diff --json`) and hands each stdout line to
+// each, without echoing it.
+func runLines(ctx context.Context, dir, cmdName string, extraArgs []string, each func(line []byte)) error {
+	if sourceMode == "files" {
+		return fmt.Errorf("%s is not available with SOURCE_MODE=files", cmdName)
+	}
+	args := append(append([]string{cmdName}, extraArgs...), "--no-lock")
+
+	if err := acquireSlot(ctx); err != nil {
+		return err
+	}
+	defer func() { <-commandSlots }()
+	defer watchSlow(ctx, dir, cmdName)()
+
+	timeout := commandTimeout(cmdName, "")
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cmd, err := resticCommand(ctx, dir, args)
+	if err != nil {
+		return err
+	}
+	cmd.WaitDelay = 5 * time.Second
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		each(scanner.Bytes())
+	}
 	if err := scanner.Err(); err != nil {
+		_ = cmd.Wait()
 		return err
 	}
-	return cmd.Wait()
+	if err := waitCommand(ctx, cmd, timeout); err != nil {
+		return fmt.Errorf("%s: %w", cmdName, err)
+	}
+	return nil
+}
+
+// resticCommand builds the command for a profile directory. resticprofile
+// style runs resticprofile inside the directory so it picks up its profiles
+// file. restic style runs plain restic with the repository taken from the
+// directory's "repository" file and, if present, the password from its
+// "password" file; anything else (e.g. cloud credentials) comes from the
+// environment.
+func resticCommand(ctx context.Context, dir string, args []string) (*exec.Cmd, error) {
+	if commandStyle != "restic" {
+		cmd := exec.CommandContext(ctx, resticBinary, args...)
+		cmd.Dir = dir
+		killGroupOnCancel(cmd)
+		return cmd, nil
+	}
+	repoFile := filepath.Join(dir, "repository")
+	if _, err := os.Stat(repoFile); err != nil {
+		return nil, fmt.Errorf("restic style needs a repository file: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, resticBin, append([]string{"--repository-file", repoFile}, args...)...)
+	cmd.Dir = dir
+	killGroupOnCancel(cmd)
+	cmd.Env = os.Environ()
+	if pw := filepath.Join(dir, "password"); fileExists(pw) {
+		cmd.Env = append(cmd.Env, "RESTIC_PASSWORD_FILE="+pw)
+	}
+	return cmd, nil
+}
+
+// absPath is filepath.Abs, falling back to path itself.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// partialError means restic exited with one of ACCEPTED_EXIT_CODES (by
+// default 3: snapshot incomplete, some files could not be read). The output
+// was parsed but may not cover everything.
+type partialError struct {
+	code int
+}
+
+func (e *partialError) Error() string {
+	return fmt.Sprintf("partial result (exit code %d)", e.code)
+}
+
+// decodeJSON decodes one JSON value from r into v. With STRICT_JSON fields
+// that v does not map are an error, to notice restic schema changes.
+func decodeJSON(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	if strictJSON {
+		dec.DisallowUnknownFields()
+	}
+	err := dec.Decode(v)
+	if err != nil && strictJSON && strings.Contains(err.Error(), "unknown field") {
+		fmt.Printf("STRICT_JSON: restic output does not match %T: %v\n", v, err)
+	}
+	return err
+}
+
+// waitCommand waits for cmd and reports a context timeout or an accepted
+// exit code as such.
+func waitCommand(ctx context.Context, cmd *exec.Cmd, timeout time.Duration) error {
+	err := cmd.Wait()
+	if cause := context.Cause(ctx); errors.Is(cause, errProfileTimeout) {
+		return cause
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && acceptedExitCodes[exitErr.ExitCode()] {
+		return &partialError{exitErr.ExitCode()}
+	}
+	return err
+}
+
+// watchSlow starts timing a command; the returned func logs and counts it
+// if it took longer than SLOW_COMMAND_SECONDS, so a degrading remote shows
+// up before it hits the timeouts.
+func watchSlow(ctx context.Context, dir, key string) func() {
+	start := time.Now()
+	return func() {
+		slow := conf().slowCommand
+		if elapsed := time.Since(start); slow > 0 && elapsed > slow {
+			logf(ctx, "Slow command: %s for %s took %s (SLOW_COMMAND_SECONDS=%d)\n",
+				key, dir, elapsed.Round(time.Millisecond), int(slow.Seconds()))
+			countSlow(key)
+		}
+	}
+}
+
+// errProfileTimeout is the cause of a profile budget running out.
+var errProfileTimeout = errors.New("profile timed out")
+
+// acquireSlot waits for one of the COMMAND_CONCURRENCY slots, or until the
+// profile's budget runs out.
+func acquireSlot(ctx context.Context) error {
+	select {
+	case commandSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// commandKey names a command by its stats mode, or by the command itself.
+func commandKey(cmdName, mode string) string {
+	if mode != "" {
+		return mode
+	}
+	return cmdName
+}
+
+// commandTimeout picks the timeout for a command: the per-command override
+// if set, otherwise RESTIC_TIMEOUT. 0 means no timeout.
+func commandTimeout(cmdName, mode string) time.Duration {
+	timeouts := conf().timeouts
+	if t, ok := timeouts[commandKey(cmdName, mode)]; ok {
+		return t
+	}
+	return timeouts[""]
+}
+
+/* human‑friendly ratio formatters */
+
+// ratioHuman and percentHuman format ratios ("1.85") and percentages
+// ("45.90%") with RATIO_PRECISION decimals.
+func ratioHuman(f float64) string {
+	return strconv.FormatFloat(f, 'f', ratioPrecision, 64)
+}
+
+func percentHuman(f float64) string {
+	return ratioHuman(f) + "%"
+}
+
+// compressionUnsupported replaces both human readable compression fields for
+// repositories in format 1, which cannot be compressed.
+const compressionUnsupported = "unsupported"
+
+// compressionSupported goes by the repository format if `cat config` told
+// us, otherwise by raw-data: restic leaves out all compression fields for
+// format 1 (and a zero ratio for an empty repository, so one is enough).
+func compressionSupported(version int, raw rawJSON) bool {
+	if version > 0 {
+		return version >= 2
+	}
+	return raw.CompressionRatio != nil || raw.CompressionSavingPct != nil
+}
+
+// savedBytes is what compression saves; 0 when the repository reports less
+// uncompressed than stored data (e.g. metadata on a repo without compression).
+func savedBytes(uncompressed, raw int64) int64 {
+	return max(uncompressed-raw, 0)
+}
+
+func deref(f *float64) float64 {
+	if f == nil {
+		return 0
+	}
+	return *f
 }
 
 /* human‑friendly byte formatter */
-type bytes float64
 
-func human(b bytes) string {
-	const unit = 1024.0
+// humanStyle (HUMANIZE_STYLE) picks the format of the *_human sizes.
+var humanStyle = getenvOr("HUMANIZE_STYLE", "ours")
+
+// byteStyle is a size format: decimals, and the largest unit as an index
+// into "KMGTPE".
+type byteStyle struct {
+	decimals int
+	maxUnit  int
+}
+
+var byteStyles = map[string]byteStyle{
+	"ours":   {decimals: 2, maxUnit: 5}, // "4.26 TiB"
+	"restic": {decimals: 3, maxUnit: 3}, // "4.258 TiB", like restic's ui.FormatBytes, which stops at TiB
+}
+
+// human formats a byte count in the HUMANIZE_STYLE format.
+func human(b int64) string {
+	return formatBytes(b, byteStyles[humanStyle])
+}
+
+// formatBytes works on integers only, so even exabyte values are rounded
+// exactly instead of drifting the way float64 does above 2^53.
+func formatBytes(b int64, style byteStyle) string {
+	const unit = 1024
 	if b < unit {
-		return fmt.Sprintf("%d B", int64(b))
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := uint64(b) / unit; n >= unit && exp < style.maxUnit; n /= unit {
+		div *= unit
+		exp++
 	}
-	exp := int(math.Log(float64(b)) / math.Log(unit))
-	pre := "KMGTPE"[exp-1]
-	val := float64(b) / math.Pow(unit, float64(exp))
-	return fmt.Sprintf("%.2f %ciB", val, pre)
+	scale := uint64(1)
+	for range style.decimals {
+		scale *= 10
+	}
+	whole, rem := uint64(b)/div, uint64(b)%div
+	// frac = round(rem * scale / div) in 128 bits, rem*scale overflows otherwise
+	hi, lo := bits.Mul64(rem, scale)
+	lo, carry := bits.Add64(lo, div/2, 0)
+	frac, _ := bits.Div64(hi+carry, lo, div)
+	if frac == scale {
+		whole, frac = whole+1, 0
+	}
+	return fmt.Sprintf("%d.%0*d %ciB", whole, style.decimals, frac, "KMGTPE"[exp])
 }
 
 /* human‑friendly time formatter */
+
+// Breakpoints of prettyTime. With the defaults times older than a day are
+// shown as a date; a larger TIME_RELATIVE_MAX_SECONDS continues in days,
+// weeks and months first.
+var (
+	timeJustNow     = time.Duration(getenvInt("TIME_JUST_NOW_SECONDS", 60)) * time.Second
+	timeRelativeMax = time.Duration(getenvInt("TIME_RELATIVE_MAX_SECONDS", 86400)) * time.Second
+)
+
+const day = 24 * time.Hour
+
 func prettyTime(t time.Time) string {
-	diff := time.Since(t)
+	diff := clock().Sub(t)
 	switch {
-	case diff < time.Minute:
+	case diff < timeJustNow:
 		return "just now"
+	case diff >= timeRelativeMax:
+		return t.Format("2006‑01‑02 15:04")
 	case diff < time.Hour:
-		return fmt.Sprintf("%d min ago", int(diff.Minutes()))
-	case diff < 24*time.Hour:
-		return fmt.Sprintf("%.1f h ago", diff.Hours())
+		return fmt.Sprintf("%d min ago", int(diff.Minutes()))
+	case diff < day:
+		return fmt.Sprintf("%.1f h ago", diff.Hours())
+	case diff < 7*day:
+		return fmt.Sprintf("%d d ago", int(diff/day))
+	case diff < 30*day:
+		return fmt.Sprintf("%d w ago", int(diff/(7*day)))
 	default:
-		return t.Format("2006‑01‑02 15:04")
+		return fmt.Sprintf("%d mo ago", int(diff/(30*day)))
 	}
 }
 
+type snapshotSummary struct {
+	Latest       time.Time
+	LatestID     string // short ID, ties broken by the full ID
+	LatestEntry  snapshotEntry
+	LastSnapshot string // human readable
+	Paths        []PathSnapshot
+	OldestPath   time.Time // oldest of the per-path latest snapshots
+	Hosts        []HostSnapshots
+	PerDay       float64
+	LargestGap   time.Duration
+}
+
 /* summariseSnapshots picks latest snapshot and per‑path latest times */
-func summariseSnapshots(snaps []snapshotEntry) (string, []PathSnapshot) {
+func summariseSnapshots(snaps []snapshotEntry) snapshotSummary {
 	var latest time.Time
+	var latestID, latestShort string
+	var latestEntry snapshotEntry
+	times := make([]time.Time, 0, len(snaps))
 	pathMap := map[string]time.Time{}
+	hostMap := map[string]*HostSnapshots{}
+	hostLatest := map[string]time.Time{}
 	for _, s := range snaps {
 		t, err := time.Parse(time.RFC3339, s.Time)
 		if err != nil {
 			continue
 		}
-		if t.After(latest) {
-			latest = t
+		times = append(times, t)
+		// equal times happen (e.g. restic copy), the higher ID wins so the
+		// result does not depend on the output order
+		if t.After(latest) || t.Equal(latest) && s.ID > latestID {
+			latest, latestID, latestShort, latestEntry = t, s.ID, shortID(s), s
 		}
 		for _, p := range s.Paths {
 			if t.After(pathMap[p]) {
 				pathMap[p] = t
 			}
 		}
+		h := hostMap[s.Hostname]
+		if h == nil {
+			h = &HostSnapshots{Host: s.Hostname}
+			hostMap[s.Hostname] = h
+		}
+		h.Snapshots++
+		if t.After(hostLatest[s.Hostname]) {
+			hostLatest[s.Hostname] = t
+		}
 	}
 	paths := make([]PathSnapshot, 0, len(pathMap))
+	var oldestPath time.Time
 	for p, t := range pathMap {
-		paths = append(paths, PathSnapshot{Path: p, LastSnapshot: prettyTime(t)})
+		paths = append(paths, PathSnapshot{Path: p, LastSnapshot: prettyTime(t), LastSnapshotUnix: t.Unix(), LastSnapshotISO: isoOrEmpty(t)})
+		if oldestPath.IsZero() || t.Before(oldestPath) {
+			oldestPath = t
+		}
+	}
+	hosts := make([]HostSnapshots, 0, len(hostMap))
+	for _, h := range hostMap {
+		t := hostLatest[h.Host]
+		h.LastSnapshot, h.LastSnapshotUnix, h.LastSnapshotISO = prettyTime(t), t.Unix(), isoOrEmpty(t)
+		hosts = append(hosts, *h)
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i].Path < paths[j].Path }) // map order is random
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return snapshotSummary{
+		Latest:       latest,
+		LatestID:     latestShort,
+		LatestEntry:  latestEntry,
+		LastSnapshot: prettyTime(latest),
+		Paths:        paths,
+		OldestPath:   oldestPath,
+		Hosts:        hosts,
+		PerDay:       snapshotsPerDay(times),
+		LargestGap:   largestGap(times),
+	}
+}
+
+func addedHuman(b int64) string {
+	if b == 0 {
+		return ""
+	}
+	return human(b)
+}
+
+func shortID(s snapshotEntry) string {
+	if s.ShortID == "" && len(s.ID) >= 8 {
+		return s.ID[:8]
+	}
+	return s.ShortID
+}
+
+// snapshotsPerDay is the average cadence over the observed window of the
+// sorted times: n snapshots span n-1 intervals. Fewer than two snapshots (or
+// all at the same instant) give no window and thus 0.
+func snapshotsPerDay(times []time.Time) float64 {
+	if len(times) < 2 {
+		return 0
+	}
+	window := times[len(times)-1].Sub(times[0])
+	if window <= 0 {
+		return 0
+	}
+	return float64(len(times)-1) / (window.Hours() / 24)
+}
+
+// largestGap is the longest interval between consecutive sorted times.
+func largestGap(times []time.Time) time.Duration {
+	var gap time.Duration
+	for i := 1; i < len(times); i++ {
+		gap = max(gap, times[i].Sub(times[i-1]))
+	}
+	return gap
+}
+
+/* staleness helpers */
+
+// snapshotAge returns how long ago the profile's latest snapshot was taken.
+// ok is false when the profile has no snapshot at all.
+func snapshotAge(p ProfileStats) (age time.Duration, ok bool) {
+	if p.LastSnapshotUnix == 0 {
+		return 0, false
+	}
+	return clock().Sub(time.Unix(p.LastSnapshotUnix, 0)), true
+}
+
+// isStale reports whether the latest snapshot is older than threshold.
+// Profiles without any snapshot are always stale.
+func isStale(p ProfileStats, threshold time.Duration) bool {
+	age, ok := snapshotAge(p)
+	return !ok || age > threshold
+}
+
+// staleFor is the one staleness rule of is_stale, stale_only and the status
+// page: isStale with threshold, or with staleThreshold if that is 0.
+func staleFor(p ProfileStats, threshold time.Duration) bool {
+	if threshold == 0 {
+		threshold = staleThreshold(p)
+	}
+	return isStale(p, threshold)
+}
+
+// staleThreshold is the threshold for a profile when none is given: its
+// PROFILE_OVERRIDES stale, derived from its backup schedule if known,
+// otherwise STALE_THRESHOLD_SECONDS.
+func staleThreshold(p ProfileStats) time.Duration {
+	if o, ok := overrideFor(p.Name); ok && o.Stale > 0 {
+		return o.Stale
+	}
+	if p.ExpectedIntervalSeconds > 0 {
+		return scheduleStaleThreshold(time.Duration(p.ExpectedIntervalSeconds) * time.Second)
 	}
-	return prettyTime(latest), paths
+	return conf().staleThreshold
+}
+
+// filterStale returns a new slice with only the stale profiles, leaving the
+// (cached) input untouched. A threshold of 0 uses staleThreshold.
+func filterStale(in []ProfileStats, threshold time.Duration) []ProfileStats {
+	out := make([]ProfileStats, 0, len(in))
+	for _, p := range in {
+		if staleFor(p, threshold) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// perSnapshot is total / snapshots, 0 when there are no snapshots.
+func perSnapshot(total, snapshots int64) float64 {
+	if snapshots <= 0 {
+		return 0
+	}
+	return float64(total) / float64(snapshots)
+}
+
+// isoOrEmpty formats t as RFC 3339 in UTC, "" for no time.
+func isoOrEmpty(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// filterNames returns a new slice with the profiles whose name matches.
+func filterNames(in []ProfileStats, match func(name string) bool) []ProfileStats {
+	out := make([]ProfileStats, 0, len(in))
+	for _, p := range in {
+		if match(p.Name) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
 }
 
 /* env helpers */
@@ -357,6 +1830,114 @@ func getenvOr(key, def string) string {
 	return def
 }
 
+func getenvInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if i, err := strconv.Atoi(v); err == nil && i > 0 {
+			return i
+		}
+	}
+	return def
+}
+
+// getRatioPrecision reads RATIO_PRECISION. Unlike getenvInt it accepts 0.
+func getRatioPrecision() int {
+	if n, err := strconv.Atoi(os.Getenv("RATIO_PRECISION")); err == nil && n >= 0 {
+		return min(n, 6)
+	}
+	return 2
+}
+
+// getDisabledStats reads DISABLE_STATS. Unlike most settings an empty value
+// counts, so DISABLE_STATS= re-enables the slow restore-size.
+func getDisabledStats() map[string]bool {
+	v, ok := os.LookupEnv("DISABLE_STATS")
+	if !ok {
+		v = "restore-size"
+	}
+	return parseSet(v)
+}
+
+// getenvSet parses a comma separated list into a set.
+func getenvSet(key string) map[string]bool {
+	return parseSet(os.Getenv(key))
+}
+
+func parseSet(list string) map[string]bool {
+	set := map[string]bool{}
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			set[v] = true
+		}
+	}
+	return set
+}
+
+// getTimeouts reads RESTIC_TIMEOUT and the per-command overrides, keyed by
+// the stats mode or command name ("" is the global default).
+func getTimeouts() map[string]time.Duration {
+	t := map[string]time.Duration{}
+	for key, env := range map[string]string{
+		"":               "RESTIC_TIMEOUT",
+		"raw-data":       "RESTIC_TIMEOUT_RAW",
+		"restore-size":   "RESTIC_TIMEOUT_RESTORE",
+		"blobs-per-file": "RESTIC_TIMEOUT_BLOBS",
+		"snapshots":      "RESTIC_TIMEOUT_SNAPSHOTS",
+		"cat":            "RESTIC_TIMEOUT_PROBE",
+	} {
+		if s := getenvInt(env, 0); s > 0 {
+			t[key] = time.Duration(s) * time.Second
+		}
+	}
+	return t
+}
+
+func getExitCodes(v string) map[int]bool {
+	codes := map[int]bool{}
+	for _, c := range strings.Split(v, ",") {
+		if i, err := strconv.Atoi(strings.TrimSpace(c)); err == nil && i != 0 {
+			codes[i] = true
+		}
+	}
+	return codes
+}
+
+// getRoutePrefix normalises ROUTE_PREFIX to "/prefix" (or "").
+func getRoutePrefix() string {
+	p := strings.Trim(os.Getenv("ROUTE_PREFIX"), "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// getStatsRoute reads STATS_ROUTE, the path /stats is also served at. Like
+// every route it is relative to ROUTE_PREFIX.
+func getStatsRoute() string {
+	p := strings.Trim(os.Getenv("STATS_ROUTE"), "/")
+	if p == "" {
+		return "/stats"
+	}
+	return "/" + p
+}
+
+func getSocketMode() os.FileMode {
+	if v := os.Getenv("SOCKET_MODE"); v != "" {
+		if m, err := strconv.ParseUint(v, 8, 32); err == nil {
+			return os.FileMode(m)
+		}
+	}
+	return 0660
+}
+
+func setKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func getCacheSeconds() int {
 	if v := os.Getenv("CACHE_SECONDS"); v != "" {
 		if s, err := strconv.Atoi(v); err == nil && s > 0 {
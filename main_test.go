@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPercentDecodesIntAndFloat(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want percent
+	}{
+		{`{"compression_progress":100}`, 100},
+		{`{"compression_progress":100.0}`, 100},
+		{`{"compression_progress":99.6}`, 100},
+		{`{"compression_progress":42.4}`, 42},
+		{`{"compression_progress":0}`, 0},
+		{`{"compression_progress":null}`, 0},
+		{`{}`, 0},
+	} {
+		var raw rawJSON
+		if err := json.Unmarshal([]byte(tc.in), &raw); err != nil {
+			t.Errorf("%s: %v", tc.in, err)
+			continue
+		}
+		if raw.CompressionProgress != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.in, raw.CompressionProgress, tc.want)
+		}
+	}
+
+	var raw rawJSON
+	if err := json.Unmarshal([]byte(`{"compression_progress":"100"}`), &raw); err == nil {
+		t.Error("string percent: decoded, want an error")
+	}
+}
+
+// resetCache empties the cache for the test and again when it ends.
+func resetCache(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		cacheMu.Lock()
+		cachedData, cachedProfiles, cachedAt = nil, nil, time.Time{}
+		cacheMu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+// TestGetStatsSingleFlight runs many concurrent requests against a cold
+// cache; run it with -race. Exactly one of them may refresh, the others wait
+// for its result, and a deadlock fails the test instead of hanging it.
+func TestGetStatsSingleFlight(t *testing.T) {
+	useFixtures(t, "files")
+	resetCache(t)
+	cacheMu.Lock()
+	oldTTL := cachedTTL
+	cachedTTL = time.Hour
+	cacheMu.Unlock()
+	t.Cleanup(func() {
+		cacheMu.Lock()
+		cachedTTL = oldTTL
+		cacheMu.Unlock()
+	})
+
+	const callers = 64
+	misses := cacheMisses.Load()
+	results := make([][]ProfileStats, callers)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			data, err := getStats(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = data
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	close(start)
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("getStats callers still waiting after 30s, deadlock?")
+	}
+
+	if n := cacheMisses.Load() - misses; n != 1 {
+		t.Errorf("%d refreshes, want 1", n)
+	}
+	for i, r := range results {
+		if len(r) == 0 || &r[0] != &results[0][0] {
+			t.Errorf("caller %d got a different result than caller 0", i)
+		}
+	}
+	computeMu.Lock()
+	running := inflight
+	computeMu.Unlock()
+	if running != nil {
+		t.Error("latch still set after all callers returned")
+	}
+}
+
+// TestGetStatsPanicReleasesLatch makes the refresh itself panic (outside the
+// per-profile workers, which recover on their own): every caller gets an
+// error, the latch is released, and the next request refreshes normally.
+func TestGetStatsPanicReleasesLatch(t *testing.T) {
+	useFixtures(t, "files")
+	resetCache(t)
+	oldProgress := progress
+	t.Cleanup(func() { progress = oldProgress })
+
+	// callers makes n concurrent getStats calls and fails the test instead of
+	// hanging if any of them never returns
+	callers := func(n int) []error {
+		errs := make([]error, n)
+		var wg sync.WaitGroup
+		for i := range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = getStats(context.Background())
+			}()
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(30 * time.Second):
+			t.Fatal("getStats callers still waiting after 30s, deadlock?")
+		}
+		return errs
+	}
+
+	// generateStats panics on its first progress.begin; captureStdout keeps
+	// the logged stack out of the test output
+	progress = nil
+	captureStdout(t, func() {
+		for i, err := range callers(8) {
+			if err == nil || !strings.Contains(err.Error(), "panic in refresh") {
+				t.Errorf("caller %d: got %v, want the panic as an error", i, err)
+			}
+		}
+	})
+	computeMu.Lock()
+	running := inflight
+	computeMu.Unlock()
+	if running != nil {
+		t.Fatal("latch still set after the refresh panicked")
+	}
+
+	progress = oldProgress
+	for i, err := range callers(1) {
+		if err != nil {
+			t.Errorf("caller %d after the panic: %v", i, err)
+		}
+	}
+}
+
+func TestRefreshProfileWaitsForFullRefresh(t *testing.T) {
+	useFixtures(t, "files")
+	resetCache(t)
+	target, _ := targetByName("basic")
+
+	// a full refresh is running and will store results from before the
+	// single-profile refresh
+	computeMu.Lock()
+	inflight = make(chan struct{})
+	computeMu.Unlock()
+
+	done := make(chan ProfileStats)
+	go func() {
+		p, err := refreshProfile(context.Background(), target)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- p
+	}()
+	select {
+	case <-done:
+		t.Fatal("refreshProfile finished while a full refresh was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	old := []ProfileStats{{Name: "basic", RepoID: "from-full-refresh"}}
+	cacheMu.Lock()
+	cachedData, cachedProfiles, cachedAt = old, old, clock()
+	cacheMu.Unlock()
+	releaseLatch()
+
+	var p ProfileStats
+	select {
+	case p = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("refreshProfile still waiting after the full refresh ended")
+	}
+	cacheMu.RLock()
+	got := cachedProfiles[0].RepoID
+	cacheMu.RUnlock()
+	if got != p.RepoID || got == "from-full-refresh" {
+		t.Errorf("cached repo ID %q, want the single-profile result %q", got, p.RepoID)
+	}
+}
+
+func TestRefreshProfileGivesUpWithContext(t *testing.T) {
+	useFixtures(t, "files")
+	resetCache(t)
+	target, _ := targetByName("basic")
+	computeMu.Lock()
+	inflight = make(chan struct{})
+	computeMu.Unlock()
+	defer releaseLatch()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := refreshProfile(ctx, target); err == nil {
+		t.Fatal("refreshProfile returned without error while a full refresh was running")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	oursStyle, resticStyle := byteStyles["ours"], byteStyles["restic"]
+	for _, tc := range []struct {
+		b            int64
+		ours, restic string
+	}{
+		{0, "0 B", "0 B"},
+		{1023, "1023 B", "1023 B"},
+		{1024, "1.00 KiB", "1.000 KiB"},
+		{1124, "1.10 KiB", "1.098 KiB"}, // 1.0977 rounds up
+		{1029, "1.00 KiB", "1.005 KiB"}, // 1.0049 rounds down with 2 decimals
+		{1536, "1.50 KiB", "1.500 KiB"},
+		{2047, "2.00 KiB", "1.999 KiB"},            // rounding carries into the whole part
+		{1<<20 - 1, "1024.00 KiB", "1023.999 KiB"}, // the unit is picked before rounding
+		{1 << 20, "1.00 MiB", "1.000 MiB"},
+		{3<<29 + 1<<20, "1.50 GiB", "1.501 GiB"},
+		{1 << 40, "1.00 TiB", "1.000 TiB"},
+		{1 << 50, "1.00 PiB", "1024.000 TiB"}, // restic stops at TiB
+		{1 << 60, "1.00 EiB", "1048576.000 TiB"},
+		{math.MaxInt64, "8.00 EiB", "8388608.000 TiB"},
+	} {
+		if got := formatBytes(tc.b, oursStyle); got != tc.ours {
+			t.Errorf("ours %d: got %q, want %q", tc.b, got, tc.ours)
+		}
+		if got := formatBytes(tc.b, resticStyle); got != tc.restic {
+			t.Errorf("restic %d: got %q, want %q", tc.b, got, tc.restic)
+		}
+	}
+}
+
+func TestStatsMatch(t *testing.T) {
+	useFixtures(t, "files")
+	resetCache(t)
+	for _, tc := range []struct {
+		match string
+		code  int
+		names []string
+	}{
+		{"bas", http.StatusOK, nil}, // whole names only
+		{"bas.*", http.StatusOK, []string{"basic"}},
+		{"basic|empty", http.StatusOK, []string{"basic", "empty"}},
+		{"(", http.StatusBadRequest, nil},
+		{"a)(b", http.StatusBadRequest, nil},
+		{"nooutput)|(?:.*", http.StatusBadRequest, nil}, // must not escape the anchors
+	} {
+		rec := httptest.NewRecorder()
+		statsHandler(rec, httptest.NewRequest("GET", "/stats?match="+url.QueryEscape(tc.match), nil))
+		if rec.Code != tc.code {
+			t.Errorf("%q: status %d, want %d", tc.match, rec.Code, tc.code)
+			continue
+		}
+		if tc.code != http.StatusOK {
+			continue
+		}
+		var got []ProfileStats
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%q: %v", tc.match, err)
+		}
+		var names []string
+		for _, p := range got {
+			names = append(names, p.Name)
+		}
+		if !slices.Equal(names, tc.names) {
+			t.Errorf("%q: got %q, want %q", tc.match, names, tc.names)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+/* ─── maintenance detection ───────────────────────────────────────────────── */
+
+// restic keeps no record of when a repository was last pruned, so it is
+// inferred: backups only ever add data, so if the stored size or the blob
+// count went down since the previous refresh, a prune ran in between. This
+// only sees prunes while the server is running and raw-data is enabled.
+
+type repoObservation struct {
+	rawBytes        int64
+	blobs           int64
+	lastMaintenance time.Time
+}
+
+var (
+	observationsMu sync.Mutex
+	observations   = map[string]repoObservation{}
+)
+
+// observeMaintenance records the latest raw-data numbers of a profile and
+// returns when it was last seen shrinking (zero if never).
+func observeMaintenance(name string, raw rawJSON) time.Time {
+	observationsMu.Lock()
+	defer observationsMu.Unlock()
+	prev, seen := observations[name]
+	cur := repoObservation{rawBytes: raw.TotalSize, blobs: raw.TotalBlobCount, lastMaintenance: prev.lastMaintenance}
+	if seen && (cur.rawBytes < prev.rawBytes || cur.blobs < prev.blobs) {
+		cur.lastMaintenance = clock()
+	}
+	observations[name] = cur
+	return cur.lastMaintenance
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+)
+
+/* ─── memory pressure ─────────────────────────────────────────────────────── */
+
+// memoryPressure is MEMORY_PRESSURE_FRACTION: above this share of GOMEMLIMIT
+// responses leave out the per-path lists. 0 = off.
+var memoryPressure = getMemoryPressure()
+
+func getMemoryPressure() float64 {
+	if f, err := strconv.ParseFloat(os.Getenv("MEMORY_PRESSURE_FRACTION"), 64); err == nil && f > 0 && f <= 1 {
+		return f
+	}
+	return 0
+}
+
+// underMemoryPressure compares the memory the runtime holds (what GOMEMLIMIT
+// counts, roughly) against the limit. Without a limit it is never true.
+func underMemoryPressure() bool {
+	if memoryPressure == 0 {
+		return false
+	}
+	limit := debug.SetMemoryLimit(-1) // -1 only reads it
+	if limit <= 0 || limit == 1<<63-1 {
+		return false
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	used := m.Sys - m.HeapReleased
+	if float64(used) < memoryPressure*float64(limit) {
+		return false
+	}
+	fmt.Printf("Memory pressure: %s of %s in use, omitting paths\n", human(int64(used)), human(limit))
+	return true
+}
+
+// dropPaths returns copies of the profiles without their Paths lists, marked
+// with PathsOmitted. The (cached) input is left untouched.
+func dropPaths(in []ProfileStats) []ProfileStats {
+	out := make([]ProfileStats, len(in))
+	for i, p := range in {
+		p.Paths = []PathSnapshot{}
+		p.PathsOmitted = true
+		out[i] = p
+	}
+	return out
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* ─── metrics ─────────────────────────────────────────────────────────────── */
+
+var (
+	// incremented by getStats(); a request that waited for another
+	// goroutine's refresh counts as a hit
+	cacheHits   atomic.Uint64
+	cacheMisses atomic.Uint64
+
+	// set by generateStats() after every refresh
+	profilesTotal  atomic.Int64
+	profilesOK     atomic.Int64
+	profilesFailed atomic.Int64
+	lastRefreshMs  atomic.Int64 // wall-clock time of the last full refresh
+
+	// commands slower than SLOW_COMMAND_SECONDS, by command
+	slowCommandsMu sync.Mutex
+	slowCommands   = map[string]uint64{}
+
+	// one series per source path can be a lot, so it is opt-in
+	metricsPerPath bool
+
+	// set at build time: go build -ldflags "-X main.version=v1.2.3"
+	version = "dev"
+
+	resticVersionOnce sync.Once
+	resticVersionStr  string
+)
+
+func init() {
+	metricsPerPath = os.Getenv("METRICS_PER_PATH") == "true"
+}
+
+// resticVersionTimeout bounds `restic version`, which runs in the first
+// /metrics request and must not hang it on a stuck binary.
+var resticVersionTimeout = 5 * time.Second
+
+// resticVersion runs `restic version` once and caches the version number
+// ("restic 0.16.4 compiled with go1.21.6 on linux/amd64" -> "0.16.4").
+// A failure or timeout is cached as "unknown" too.
+func resticVersion() string {
+	resticVersionOnce.Do(func() {
+		resticVersionStr = "unknown"
+		ctx, cancel := context.WithTimeout(context.Background(), resticVersionTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, resticBin, "version")
+		cmd.WaitDelay = time.Second
+		out, err := cmd.Output()
+		if err != nil {
+			fmt.Printf("restic version: %v\n", err)
+			return
+		}
+		if f := strings.Fields(string(out)); len(f) >= 2 && f[0] == "restic" {
+			resticVersionStr = f[1]
+		}
+	})
+	return resticVersionStr
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	res, err := getStats(r.Context())
+	if err != nil {
+		statsError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	// ?profile=NAME: only that profile's series, nothing at all if unknown
+	if name := r.URL.Query().Get("profile"); name != "" {
+		if res = filterNames(res, func(n string) bool { return n == name }); len(res) > 0 {
+			writeProfileMetrics(w, withHealth(res))
+		}
+		return
+	}
+
+	hits, misses := cacheHits.Load(), cacheMisses.Load()
+	ratio := 0.0
+	if hits+misses > 0 {
+		ratio = float64(hits) / float64(hits+misses)
+	}
+	writeMetric(w, "resticprofile_stat_server_cache_hits_total", "counter",
+		"Stats requests served from the cache.", float64(hits))
+	writeMetric(w, "resticprofile_stat_server_cache_misses_total", "counter",
+		"Stats requests that triggered a refresh.", float64(misses))
+	writeMetric(w, "resticprofile_stat_server_cache_hit_ratio", "gauge",
+		"Share of stats requests served from the cache.", ratio)
+
+	writeMetric(w, "resticprofile_profiles_total", "gauge",
+		"Profiles found by the last refresh.", float64(profilesTotal.Load()))
+	writeMetric(w, "resticprofile_profiles_ok", "gauge",
+		"Profiles collected successfully by the last refresh.", float64(profilesOK.Load()))
+	writeMetric(w, "resticprofile_profiles_failed", "gauge",
+		"Profiles that failed in the last refresh.", float64(profilesFailed.Load()))
+
+	if conf().slowCommand > 0 {
+		writeHeader(w, "resticprofile_stat_server_slow_commands_total", "counter",
+			"Restic commands that took longer than SLOW_COMMAND_SECONDS.")
+		slowCommandsMu.Lock()
+		keys := make([]string, 0, len(slowCommands))
+		for key := range slowCommands {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, "resticprofile_stat_server_slow_commands_total{command=\"%s\"} %d\n", labelValue(key), slowCommands[key])
+		}
+		slowCommandsMu.Unlock()
+	}
+
+	writeHeader(w, "resticprofile_stat_server_build_info", "gauge", "Build information, always 1.")
+	fmt.Fprintf(w, "resticprofile_stat_server_build_info{version=\"%s\",restic_version=\"%s\",go_version=\"%s\"} 1\n",
+		labelValue(version), labelValue(resticVersion()), labelValue(runtime.Version()))
+
+	writeProfileMetrics(w, withHealth(res))
+}
+
+type metricSeries struct {
+	name, help string
+	value      func(p ProfileStats) (float64, bool)
+}
+
+func always(f func(p ProfileStats) float64) func(p ProfileStats) (float64, bool) {
+	return func(p ProfileStats) (float64, bool) { return f(p), true }
+}
+
+// profileSeries are the per-profile gauges, shared by /metrics and
+// STDOUT_METRICS.
+var profileSeries = []metricSeries{
+	{"resticprofile_snapshots", "Number of snapshots in the repository.",
+		always(func(p ProfileStats) float64 { return float64(p.Snapshots) })},
+	{"resticprofile_restore_bytes", "Restore size of all snapshots in bytes.",
+		always(func(p ProfileStats) float64 { return float64(p.RestoreBytes) })},
+	{"resticprofile_files_per_snapshot", "Average number of files per snapshot (needs restore-size).",
+		func(p ProfileStats) (float64, bool) { return p.FilesPerSnapshot, p.FilesPerSnapshot != 0 }},
+	{"resticprofile_raw_bytes", "Raw (stored) repository size in bytes.",
+		always(func(p ProfileStats) float64 { return float64(p.RawBytes) })},
+	{"resticprofile_uncompressed_bytes", "Uncompressed repository size in bytes.",
+		always(func(p ProfileStats) float64 { return float64(p.UncompBytes) })},
+	{"resticprofile_compression_ratio", "Repository compression ratio.",
+		always(func(p ProfileStats) float64 { return p.CompressRatio })},
+	{"resticprofile_compression_saved_bytes", "Bytes saved by compression (uncompressed minus raw size).",
+		always(func(p ProfileStats) float64 { return float64(p.SavedBytes) })},
+	{"resticprofile_refresh_duration_seconds", "Time the last refresh of the profile took.",
+		always(func(p ProfileStats) float64 { return float64(p.RefreshDurationMs) / 1000 })},
+	{"resticprofile_snapshot_age_seconds", "Seconds since the latest snapshot.",
+		func(p ProfileStats) (float64, bool) {
+			age, ok := snapshotAge(p)
+			return age.Seconds(), ok
+		}},
+	{"resticprofile_healthy", "1 if the profile passes the HEALTH_* rules.",
+		always(func(p ProfileStats) float64 {
+			if p.Healthy {
+				return 1
+			}
+			return 0
+		})},
+	{"resticprofile_repo_version", "Repository format version, 1 or 2 (2 supports compression).",
+		func(p ProfileStats) (float64, bool) { return float64(p.RepoVersion), p.RepoVersion != 0 }},
+	{"resticprofile_offline", "1 if the repository is unreachable and its values are from the last good refresh (OFFLINE_FALLBACK).",
+		func(p ProfileStats) (float64, bool) {
+			if p.Offline {
+				return 1, true
+			}
+			return 0, offlineFallback
+		}},
+	{"resticprofile_last_maintenance_timestamp_seconds", "Unix time the repository was last seen shrinking (prune).",
+		func(p ProfileStats) (float64, bool) { return float64(p.LastMaintenance), p.LastMaintenance != 0 }},
+}
+
+func writeProfileMetrics(w io.Writer, res []ProfileStats) {
+	for _, s := range profileSeries {
+		writeHeader(w, s.name, "gauge", s.help)
+		for _, p := range res {
+			if v, ok := s.value(p); ok {
+				fmt.Fprintf(w, "%s{profile=\"%s\"} %g\n", s.name, labelValue(p.Name), v)
+			}
+		}
+	}
+
+	if lastDelta {
+		s := "resticprofile_last_snapshot_added_bytes"
+		writeHeader(w, s, "gauge", "Data added by the latest snapshot (LAST_DELTA).")
+		for _, p := range res {
+			if p.LastSnapshotAdded > 0 {
+				fmt.Fprintf(w, "%s{profile=\"%s\"} %d\n", s, labelValue(p.Name), p.LastSnapshotAdded)
+			}
+		}
+	}
+
+	if checkLocksEnabled {
+		for _, s := range []metricSeries{
+			{"resticprofile_locks", "Number of locks in the repository.",
+				always(func(p ProfileStats) float64 { return float64(p.Locks) })},
+			{"resticprofile_stale_lock", "1 if a lock is older than LOCK_STALE_SECONDS and probably needs `restic unlock`.",
+				always(func(p ProfileStats) float64 {
+					if p.HasStaleLock {
+						return 1
+					}
+					return 0
+				})},
+		} {
+			writeHeader(w, s.name, "gauge", s.help)
+			for _, p := range res {
+				v, _ := s.value(p)
+				fmt.Fprintf(w, "%s{profile=\"%s\"} %g\n", s.name, labelValue(p.Name), v)
+			}
+		}
+	}
+
+	if !metricsPerPath {
+		return
+	}
+	const name = "resticprofile_path_snapshot_age_seconds"
+	writeHeader(w, name, "gauge", "Seconds since the latest snapshot of a source path.")
+	for _, p := range res {
+		for _, ps := range p.Paths {
+			age := clock().Sub(time.Unix(ps.LastSnapshotUnix, 0)).Seconds()
+			fmt.Fprintf(w, "%s{profile=\"%s\",path=\"%s\"} %g\n", name, labelValue(p.Name), labelValue(ps.Path), age)
+		}
+	}
+}
+
+func countSlow(key string) {
+	slowCommandsMu.Lock()
+	slowCommands[key]++
+	slowCommandsMu.Unlock()
+}
+
+// labelEscaper escapes a label value as the exposition format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue makes a profile name, path or version safe to put between the
+// quotes of a label; bytes that are not UTF-8 become U+FFFD.
+func labelValue(v string) string {
+	return labelEscaper.Replace(strings.ToValidUTF8(v, "\uFFFD"))
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// writeMetric writes a single unlabeled sample with its HELP and TYPE lines.
+func writeMetric(w io.Writer, name, typ, help string, value float64) {
+	writeHeader(w, name, typ, help)
+	fmt.Fprintf(w, "%s %g\n", name, value)
+}
+
+/* ─── metrics on stdout ───────────────────────────────────────────────────── */
+
+// stdoutMetrics (STDOUT_METRICS) prints one JSON line after every refresh,
+// for log based pipelines like Vector or Fluent Bit that parse stdout.
+var stdoutMetrics = os.Getenv("STDOUT_METRICS") == "true"
+
+// printMetricsLine writes the profileSeries values of all profiles, keyed
+// without the "resticprofile_" prefix, as a single line.
+func printMetricsLine(res []ProfileStats) {
+	profiles := make([]map[string]interface{}, 0, len(res))
+	for _, p := range withHealth(res) {
+		m := map[string]interface{}{"profile": p.Name}
+		for _, s := range profileSeries {
+			if v, ok := s.value(p); ok {
+				m[strings.TrimPrefix(s.name, "resticprofile_")] = v
+			}
+		}
+		if lastDelta {
+			m["last_snapshot_added_bytes"] = p.LastSnapshotAdded
+		}
+		if checkLocksEnabled {
+			m["locks"], m["stale_lock"] = p.Locks, 0
+			if p.HasStaleLock {
+				m["stale_lock"] = 1
+			}
+		}
+		profiles = append(profiles, m)
+	}
+	line, err := json.Marshal(map[string]interface{}{
+		"type":     "resticprofile_metrics",
+		"time":     clock().UTC().Format(time.RFC3339),
+		"profiles": profiles,
+	})
+	if err != nil {
+		fmt.Printf("stdout metrics: %v\n", err)
+		return
+	}
+	os.Stdout.Write(append(line, '\n')) // one write, so it stays one line
+}
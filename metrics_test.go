@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestResticVersionTimesOut(t *testing.T) {
+	hang := filepath.Join(t.TempDir(), "restic")
+	if err := os.WriteFile(hang, []byte("#!/bin/sh\nexec sleep 60\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	oldBin, oldTimeout := resticBin, resticVersionTimeout
+	resticBin, resticVersionTimeout = hang, 100*time.Millisecond
+	resticVersionOnce = sync.Once{}
+	t.Cleanup(func() {
+		resticBin, resticVersionTimeout = oldBin, oldTimeout
+		resticVersionOnce = sync.Once{}
+	})
+
+	start := time.Now()
+	if got := resticVersion(); got != "unknown" {
+		t.Errorf("got %q, want unknown", got)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("took %s, want the timeout", took)
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"plain/name", "plain/name"},
+		{`say "hi"`, `say \"hi\"`},
+		{`C:\backup`, `C:\\backup`},
+		{"two\nlines", `two\nlines`},
+		{`\"` + "\n", `\\\"\n`},
+		{"bad\xffbyte", "bad\uFFFDbyte"},
+		{`bäckup ☃ "prod"`, `bäckup ☃ \"prod\"`},
+	} {
+		if got := labelValue(tc.in); got != tc.want {
+			t.Errorf("labelValue(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// sampleLine is a sample of the text exposition format with at most one
+// label; a value may hold any UTF-8 but only escaped \\, \" and \n.
+var sampleLine = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*(\{[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\[\\"n])*"\})? \S+$`)
+
+// TestMetricsEscapedProfileName checks that a hostile profile name cannot
+// break out of its label or start a new sample line, and that any other
+// name comes through unchanged.
+func TestMetricsEscapedProfileName(t *testing.T) {
+	for _, tc := range []struct{ name, label string }{
+		{"evil\"} 1\nfake_metric{x=\"\\", `evil\"} 1\nfake_metric{x=\"\\`},
+		{`bäckup ☃ "prod"`, `bäckup ☃ \"prod\"`},
+		{"bad\xffbyte", "bad\uFFFDbyte"},
+	} {
+		var buf bytes.Buffer
+		writeProfileMetrics(&buf, []ProfileStats{{Name: tc.name, RawBytes: 1}})
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+				continue
+			}
+			if !utf8.ValidString(line) || !sampleLine.MatchString(line) {
+				t.Errorf("%q: invalid sample line %q", tc.name, line)
+			}
+		}
+		if want := `resticprofile_raw_bytes{profile="` + tc.label + `"} 1` + "\n"; !strings.Contains(buf.String(), want) {
+			t.Errorf("no %s in:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+)
+
+/* ─── HTTP middleware ─────────────────────────────────────────────────────── */
+
+var maxRequests int // MAX_CONCURRENT_REQUESTS, 0 = unlimited
+
+func init() {
+	maxRequests = getenvInt("MAX_CONCURRENT_REQUESTS", 0)
+}
+
+// limitRequests rejects requests with 503 once max handlers are running.
+// Waiting for a refresh is cheap thanks to the single-flight cache, so this
+// mainly bounds the memory spent on encoding responses.
+func limitRequests(next http.Handler, max int) http.Handler {
+	if max <= 0 {
+		return next
+	}
+	slots := make(chan struct{}, max)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many concurrent requests", http.StatusServiceUnavailable)
+		}
+	})
+}
+
+type requestIDKey struct{}
+
+// withRequestID takes the caller's X-Request-ID (or makes one up), echoes it
+// in the response and puts it into the request context, so every log line of
+// the request, including a refresh it triggered, can be correlated.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			b := make([]byte, 8)
+			_, _ = rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// validRequestID accepts short IDs of printable ASCII, so a client cannot
+// inject line breaks into the log.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// logf is fmt.Printf with the request ID of ctx, if any, in front.
+func logf(ctx context.Context, format string, args ...interface{}) {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		format = "[" + id + "] " + format
+	}
+	fmt.Printf(format, args...)
+}
+
+// recoverPanics turns a panicking handler into a 500 with a JSON error body
+// and logs the stack, so a bug triggered by one request or profile does not
+// take the server down.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v) // deliberate abort, net/http handles it quietly
+			}
+			logf(r.Context(), "ERROR: panic serving %s: %v\n%s", r.URL.Path, v, debug.Stack())
+			h := w.Header()
+			h.Del("Content-Length") // whatever the handler meant to send
+			h.Set("Content-Type", "application/json")
+			h.Set("X-Content-Type-Options", "nosniff")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = io.WriteString(w, `{"error":"internal server error"}`+"\n")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// safely runs f and returns a panic in it as an error (logging the stack).
+// Goroutines that do work for a request use it: recoverPanics only sees
+// panics in the handler goroutine itself.
+func safely(what string, f func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			fmt.Printf("ERROR: panic in %s: %v\n%s", what, v, debug.Stack())
+			err = fmt.Errorf("panic in %s: %v", what, v)
+		}
+	}()
+	return f()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		var m map[string]int
+		m["boom"]++ // nil map
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	srv := httptest.NewServer(recoverPanics(mux))
+	defer srv.Close()
+
+	for range 3 { // keeps going after each panic
+		resp, err := http.Get(srv.URL + "/panic")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Error string `json:"error"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("status %d, want 500", resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type %q, want application/json", ct)
+		}
+		if err != nil || body.Error == "" {
+			t.Errorf("body: %+v (%v), want a JSON error", body, err)
+		}
+
+		resp, err = http.Get(srv.URL + "/ok")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("after a panic: status %d, want 200", resp.StatusCode)
+		}
+	}
+}
+
+// captureStdout returns what f printed.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	defer func() { os.Stdout = old }()
+	f()
+	w.Close()
+	return <-out
+}
+
+// TestRequestIDInLogs checks that the cache check and the repository ID
+// lookup log with the request ID.
+func TestRequestIDInLogs(t *testing.T) {
+	useFixtures(t, "commands")
+	resetCache(t)
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req-1")
+
+	out := captureStdout(t, func() {
+		freshCache(ctx)
+		repoID(ctx, "missing", t.TempDir()) // no config.json: cat config fails
+	})
+	for _, want := range []string{"Cache hit", "cat config for"} {
+		found := false
+		for _, line := range strings.Split(out, "\n") {
+			if strings.Contains(line, want) {
+				found = true
+				if !strings.HasPrefix(line, "[req-1] ") {
+					t.Errorf("%q: no request ID", line)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("no %q line in %q", want, out)
+		}
+	}
+}
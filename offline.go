@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+/* ─── offline repositories ────────────────────────────────────────────────── */
+
+// With OFFLINE_FALLBACK=true a profile whose refresh fails and whose
+// repository does not answer `cat config` (an unplugged drive, a NAS that is
+// off) is served with its last good values and marked offline, instead of
+// disappearing. OFFLINE_CACHE_FILE keeps those values across restarts.
+var (
+	offlineFallback = os.Getenv("OFFLINE_FALLBACK") == "true"
+	offlineFile     = os.Getenv("OFFLINE_CACHE_FILE")
+
+	lastGoodMu sync.Mutex
+	lastGood   = map[string]ProfileStats{} // by profile name, successful refreshes only
+)
+
+// rememberGood records a successfully collected profile.
+func rememberGood(p ProfileStats) {
+	if !offlineFallback {
+		return
+	}
+	lastGoodMu.Lock()
+	lastGood[p.Name] = p
+	lastGoodMu.Unlock()
+}
+
+// offlineStats is the fallback for a profile that failed to refresh: its
+// last good values with Offline set, if it has any and the repository is
+// unreachable. A reachable repository means the failure is something else,
+// and the profile fails as usual.
+func offlineStats(ctx context.Context, t profileTarget) (ProfileStats, bool) {
+	if !offlineFallback {
+		return ProfileStats{}, false
+	}
+	lastGoodMu.Lock()
+	p, ok := lastGood[t.Name]
+	lastGoodMu.Unlock()
+	if !ok {
+		return ProfileStats{}, false
+	}
+	var cfg repoConfigJSON
+	err := runAndParse(context.WithoutCancel(ctx), t.path(), "cat", "", []string{"config"}, &cfg)
+	if err == nil {
+		return ProfileStats{}, false
+	}
+	since := clock()
+	failuresMu.Lock()
+	if f, ok := failures[t.Name]; ok {
+		since = time.Unix(f.Since, 0)
+	}
+	failuresMu.Unlock()
+	p.Offline, p.OfflineSince = true, since.Unix()
+	p.Warnings = append(append([]string(nil), p.Warnings...), fmt.Sprintf("offline: %v", err))
+	logf(ctx, "%s: repository unreachable, serving the stats of its last good refresh\n", t.Name)
+	return p, true
+}
+
+// saveLastGood writes the last good values to OFFLINE_CACHE_FILE, through
+// a temporary file so a crash never leaves half of it behind.
+func saveLastGood() error {
+	if !offlineFallback || offlineFile == "" {
+		return nil
+	}
+	lastGoodMu.Lock()
+	data, err := json.Marshal(lastGood)
+	lastGoodMu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := offlineFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, offlineFile)
+}
+
+// loadLastGood reads OFFLINE_CACHE_FILE at startup; a missing file is fine.
+func loadLastGood() error {
+	if !offlineFallback || offlineFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(offlineFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	lastGoodMu.Lock()
+	defer lastGoodMu.Unlock()
+	return json.Unmarshal(data, &lastGood)
+}
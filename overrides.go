@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* ─── per-profile overrides ───────────────────────────────────────────────── */
+
+// profileOverride replaces global settings for one profile directory
+// (PROFILE_OVERRIDES), e.g. a longer timeout for a slow cloud repository.
+type profileOverride struct {
+	Timeout     time.Duration // instead of PROFILE_TIMEOUT
+	Stale       time.Duration // stale threshold, instead of the schedule or the 24h default
+	DisplayName string
+	Args        []string // appended to the stats and snapshots commands
+}
+
+// parseOverrides parses "dir=timeout:3600,stale:172800,name:Offsite;other=args:--insecure-tls"
+// (the groups syntax, with key:value members). On an error the entries
+// before it are still returned.
+func parseOverrides(v string) (map[string]profileOverride, error) {
+	out := map[string]profileOverride{}
+	for _, def := range strings.Split(v, ";") {
+		dir, members, ok := strings.Cut(strings.TrimSpace(def), "=")
+		dir = strings.TrimSpace(dir)
+		if !ok || dir == "" {
+			if strings.TrimSpace(def) != "" {
+				return out, fmt.Errorf("%q: want dir=key:value,...", def)
+			}
+			continue
+		}
+		var o profileOverride
+		for _, m := range strings.Split(members, ",") {
+			key, val, _ := strings.Cut(strings.TrimSpace(m), ":")
+			val = strings.TrimSpace(val)
+			switch key {
+			case "timeout", "stale":
+				s, err := strconv.Atoi(val)
+				if err != nil || s <= 0 {
+					return out, fmt.Errorf("%s: %s wants positive seconds, got %q", dir, key, val)
+				}
+				if key == "timeout" {
+					o.Timeout = time.Duration(s) * time.Second
+				} else {
+					o.Stale = time.Duration(s) * time.Second
+				}
+			case "name":
+				o.DisplayName = val
+			case "args":
+				o.Args = strings.Fields(val)
+			default:
+				return out, fmt.Errorf("%s: unknown override %q, want timeout, stale, name or args", dir, key)
+			}
+		}
+		out[dir] = o
+	}
+	return out, nil
+}
+
+// overrideFor returns the override of the directory behind a row name
+// ("dir" or "dir@value").
+func overrideFor(name string) (profileOverride, bool) {
+	dir, _, _ := strings.Cut(name, "@")
+	o, ok := conf().overrides[dir]
+	return o, ok
+}
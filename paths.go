@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+/* ─── profile paths ───────────────────────────────────────────────────────── */
+
+var errBadPath = errors.New("invalid path")
+
+// profilePath resolves a profile selector ("prod/db", relative to DATA_ROOT)
+// to the absolute directory that ProfileStats.SourceDir holds. Absolute
+// paths, ".." and symlinks leading outside DATA_ROOT give errBadPath.
+func profilePath(rel string) (string, error) {
+	c := filepath.Clean(filepath.FromSlash(rel))
+	if !filepath.IsLocal(c) || c == "." {
+		return "", errBadPath
+	}
+	dir := absPath(filepath.Join(dataRoot, c))
+	if !withinDataRoot(dir) {
+		return "", errBadPath
+	}
+	return dir, nil
+}
+
+// withinDataRoot checks the resolved form of an absolute path, with symlinks
+// followed, against the resolved DATA_ROOT.
+func withinDataRoot(path string) bool {
+	root := absPath(dataRoot)
+	if r, err := resolvePath(root); err == nil {
+		root = r
+	}
+	p, err := resolvePath(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != "." && filepath.IsLocal(rel)
+}
+
+// resolvePath is filepath.EvalSymlinks for paths that may not exist yet: the
+// longest existing prefix is resolved and the rest appended. A dangling
+// symlink on the way is errBadPath, nobody knows where it will lead.
+func resolvePath(path string) (string, error) {
+	p, err := filepath.EvalSymlinks(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		return p, err
+	}
+	dir, rest := path, ""
+	for {
+		fi, err := os.Lstat(dir)
+		switch {
+		case err == nil && fi.Mode()&os.ModeSymlink != 0:
+			return "", errBadPath
+		case err == nil:
+			r, err := filepath.EvalSymlinks(dir)
+			if err != nil {
+				return "", err
+			}
+			return filepath.Join(r, rest), nil
+		case !errors.Is(err, os.ErrNotExist):
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return path, nil
+		}
+		dir, rest = parent, filepath.Join(filepath.Base(dir), rest)
+	}
+}
+
+// filterPath keeps the rows of one profile directory: the profile itself or
+// all of its scopes. Group rows have no directory and never match.
+func filterPath(in []ProfileStats, dir string) []ProfileStats {
+	out := make([]ProfileStats, 0, 1)
+	for _, p := range in {
+		if p.SourceDir == dir {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfilePathTraversal(t *testing.T) {
+	useFixtures(t, "files")
+	base := t.TempDir()
+	dataRoot = filepath.Join(base, "data")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(dataRoot, "prod", "db"), filepath.Join(outside, "secret")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"escape":   outside,                           // absolute, leaves DATA_ROOT
+		"relative": filepath.Join("..", "outside"),    // relative, leaves DATA_ROOT
+		"alias":    filepath.Join(dataRoot, "prod"),   // stays inside
+		"dangling": filepath.Join(outside, "nothing"), // leads nowhere
+	} {
+		if err := os.Symlink(target, filepath.Join(dataRoot, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		rel  string
+		want string // "" = errBadPath
+	}{
+		{"prod/db", filepath.Join(dataRoot, "prod", "db")},
+		{"prod/./db/", filepath.Join(dataRoot, "prod", "db")},
+		{"prod/../prod/db", filepath.Join(dataRoot, "prod", "db")},
+		{"alias/db", filepath.Join(dataRoot, "alias", "db")},
+		{"new", filepath.Join(dataRoot, "new")}, // not there yet, only checked lexically
+		{"", ""},
+		{".", ""},
+		{"..", ""},
+		{"../outside", ""},
+		{"prod/../../outside/secret", ""},
+		{"prod/db/../../..", ""},
+		{outside, ""},
+		{"/etc", ""},
+		{"escape", ""},
+		{"escape/secret", ""},
+		{"relative/secret", ""},
+		{"dangling", ""},
+		{"dangling/deeper", ""},
+		{"prod/new/deeper", filepath.Join(dataRoot, "prod", "new", "deeper")},
+	} {
+		got, err := profilePath(tc.rel)
+		if tc.want == "" {
+			if !errors.Is(err, errBadPath) {
+				t.Errorf("%q: got %q, %v, want errBadPath", tc.rel, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%q: got %q, %v, want %q", tc.rel, got, err, tc.want)
+		}
+	}
+
+	// the handlers reject them before running anything
+	for _, rel := range []string{"../outside/secret", "/etc", "escape/secret"} {
+		rec := httptest.NewRecorder()
+		refreshHandler(rec, httptest.NewRequest("POST", "/stats/refresh?profile="+url.QueryEscape(rel), nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("refresh %q: status %d, want 400", rel, rec.Code)
+		}
+		rec = httptest.NewRecorder()
+		statsHandler(rec, httptest.NewRequest("GET", "/stats?path="+url.QueryEscape(rel), nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("stats?path=%q: status %d, want 400", rel, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// killGroupOnCancel is a no-op without process groups; only the direct
+// child is killed on timeout.
+func killGroupOnCancel(cmd *exec.Cmd) {}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+/* ─── refresh progress ────────────────────────────────────────────────────── */
+
+const (
+	progressPending = "pending"
+	progressRunning = "in_progress"
+	progressDone    = "done"
+	progressFailed  = "failed"
+)
+
+// refreshProgress tracks the profiles of the current (or last) full refresh
+// for /stats/progress. generateStats' workers update it as they go.
+type refreshProgress struct {
+	mu       sync.Mutex
+	running  bool
+	started  time.Time
+	finished time.Time
+	names    []string          // directory order
+	state    map[string]string // by name, one of the progress* states
+}
+
+var progress = &refreshProgress{state: map[string]string{}}
+
+func (p *refreshProgress) begin(names []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running, p.started, p.finished = true, time.Now(), time.Time{}
+	p.names = names
+	p.state = make(map[string]string, len(names))
+	for _, n := range names {
+		p.state[n] = progressPending
+	}
+}
+
+func (p *refreshProgress) set(name, state string) {
+	p.mu.Lock()
+	p.state[name] = state
+	p.mu.Unlock()
+}
+
+func (p *refreshProgress) end() {
+	p.mu.Lock()
+	p.running, p.finished = false, time.Now()
+	p.mu.Unlock()
+}
+
+// progressJSON is the /stats/progress response. Every list is in directory
+// order; after a refresh, pending and in_progress are empty.
+type progressJSON struct {
+	Running      bool     `json:"running"`
+	StartedUnix  int64    `json:"started_unix"`
+	FinishedUnix int64    `json:"finished_unix"`
+	ElapsedMs    int64    `json:"elapsed_ms"`
+	Total        int      `json:"total"`
+	Done         []string `json:"done"`
+	Failed       []string `json:"failed"`
+	InProgress   []string `json:"in_progress"`
+	Pending      []string `json:"pending"`
+}
+
+func (p *refreshProgress) snapshot() progressJSON {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := progressJSON{
+		Running:      p.running,
+		StartedUnix:  unixOrZero(p.started),
+		FinishedUnix: unixOrZero(p.finished),
+		Total:        len(p.names),
+		Done:         []string{},
+		Failed:       []string{},
+		InProgress:   []string{},
+		Pending:      []string{},
+	}
+	switch {
+	case p.running:
+		out.ElapsedMs = time.Since(p.started).Milliseconds()
+	case !p.started.IsZero():
+		out.ElapsedMs = p.finished.Sub(p.started).Milliseconds()
+	}
+	for _, n := range p.names {
+		switch p.state[n] {
+		case progressDone:
+			out.Done = append(out.Done, n)
+		case progressFailed:
+			out.Failed = append(out.Failed, n)
+		case progressRunning:
+			out.InProgress = append(out.InProgress, n)
+		default:
+			out.Pending = append(out.Pending, n)
+		}
+	}
+	return out
+}
+
+// progressHandler serves /stats/progress. Unlike the other endpoints it
+// never waits for or starts a refresh.
+func progressHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = writeJSONResponse(w, http.StatusOK, progress.snapshot(), jsonOptions(r))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+/* ─── range checks ────────────────────────────────────────────────────────── */
+
+// rangeCheck keeps nonsense values from restic out of the stats: negative
+// sizes and counts, a compression ratio below 1 (0 stays, it means nothing
+// was compressed) and percentages outside 0..100. Each clamped value gives
+// a warning for the profile and a log line.
+type rangeCheck struct {
+	ctx      context.Context
+	name     string
+	warnings []string
+}
+
+func (c *rangeCheck) warn(cmd, field string, was, now float64) {
+	w := fmt.Sprintf("%s: %s %s out of range, clamped to %s", cmd, field,
+		strconv.FormatFloat(was, 'f', -1, 64), strconv.FormatFloat(now, 'f', -1, 64))
+	logf(c.ctx, "%s: %s\n", c.name, w)
+	c.warnings = append(c.warnings, w)
+}
+
+func (c *rangeCheck) clamp(cmd, field string, v *float64, lo, hi float64) {
+	if was := *v; was < lo || was > hi {
+		*v = min(max(was, lo), hi)
+		c.warn(cmd, field, was, *v)
+	}
+}
+
+func (c *rangeCheck) nonNegative(cmd, field string, v *int64) {
+	if *v < 0 {
+		c.warn(cmd, field, float64(*v), 0)
+		*v = 0
+	}
+}
+
+func (c *rangeCheck) raw(raw *rawJSON) {
+	c.nonNegative("raw-data", "total_size", &raw.TotalSize)
+	c.nonNegative("raw-data", "total_uncompressed_size", &raw.TotalUncompressed)
+	c.nonNegative("raw-data", "total_blob_count", &raw.TotalBlobCount)
+	c.nonNegative("raw-data", "snapshots_count", &raw.SnapshotsCount)
+	if r := raw.CompressionRatio; r != nil && *r != 0 {
+		lo := 1.0
+		if *r < 0 {
+			lo = 0
+		}
+		c.clamp("raw-data", "compression_ratio", r, lo, math.Inf(1))
+	}
+	if s := raw.CompressionSavingPct; s != nil {
+		c.clamp("raw-data", "compression_space_saving", s, 0, 100)
+	}
+	progress := float64(raw.CompressionProgress)
+	c.clamp("raw-data", "compression_progress", &progress, 0, 100)
+	raw.CompressionProgress = percent(progress)
+}
+
+func (c *rangeCheck) restore(restore *restoreJSON) {
+	c.nonNegative("restore-size", "total_size", &restore.TotalSize)
+	c.nonNegative("restore-size", "total_file_count", &restore.TotalFileCount)
+	c.nonNegative("restore-size", "snapshots_count", &restore.SnapshotsCount)
+}
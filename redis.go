@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* ─── shared cache (Redis) ────────────────────────────────────────────────── */
+
+// With REDIS_URL set, replicas share one cache: a refresh first looks for
+// stats another replica stored, and only the replica holding the lock runs
+// restic. Any Redis error falls back to generating locally.
+var (
+	redisURL     = os.Getenv("REDIS_URL") // redis://[user:password@]host[:port][/db], rediss:// for TLS
+	redisKey     = getenvOr("REDIS_KEY", "resticprofile-stat-server")
+	redisLockTTL = time.Duration(getenvInt("REDIS_LOCK_SECONDS", 600)) * time.Second
+	redisWait    = time.Duration(getenvInt("REDIS_WAIT_SECONDS", 60)) * time.Second // then refresh locally
+)
+
+const redisTimeout = 2 * time.Second
+
+// sharedStats is what goes into Redis. Hosts and Activity are kept apart
+// because ProfileStats leaves them out of its JSON. Failures and the counts
+// let the other replicas serve /stats/failures and the refresh metrics as if
+// they had refreshed themselves.
+type sharedStats struct {
+	GeneratedAt time.Time                  `json:"generated_at"`
+	Profiles    []ProfileStats             `json:"profiles"`
+	Hosts       map[string][]HostSnapshots `json:"hosts,omitempty"`
+	Activity    map[string][]snapshotEntry `json:"activity,omitempty"`
+	Failures    []ProfileFailure           `json:"failures,omitempty"`
+	Total       int64                      `json:"profiles_total"`
+	Failed      int64                      `json:"profiles_failed"`
+	RefreshMs   int64                      `json:"refresh_duration_ms"`
+}
+
+// sharedGenerate is generateStats behind the shared cache. It returns the
+// stats and when they were generated, which for stats from another replica
+// is earlier than now.
+func sharedGenerate(ctx context.Context) ([]ProfileStats, time.Time, error) {
+	local := func(what string, err error) ([]ProfileStats, time.Time, error) {
+		logf(ctx, "Redis %s failed, refreshing locally: %v\n", what, err)
+		stats, err := generateStats(ctx, liveRefresh.publish)
+		return stats, clock(), err
+	}
+	// one connection for the polling below, closed before restic runs
+	c, err := dialRedis()
+	if err != nil {
+		return local("connect", err)
+	}
+	defer c.Close()
+	var waitLimit <-chan time.Time // set once we start waiting
+	for {
+		if s, ok, err := sharedLoad(c); err != nil {
+			return local("read", err)
+		} else if ok {
+			logf(ctx, "Using stats from the shared cache, generated at %s\n", s.GeneratedAt.Format(time.RFC3339))
+			adoptShared(ctx, s)
+			return s.Profiles, s.GeneratedAt, nil
+		}
+		token, ok, err := sharedLock(c)
+		if err != nil {
+			return local("lock", err)
+		}
+		if !ok {
+			if waitLimit == nil {
+				logf(ctx, "Another replica is refreshing, waiting for its stats\n")
+				t := time.NewTimer(redisWait)
+				defer t.Stop()
+				waitLimit = t.C
+			}
+			select {
+			case <-ctx.Done():
+				return nil, time.Time{}, context.Cause(ctx)
+			case <-waitLimit:
+				return local("wait", fmt.Errorf("no stats from the other replica after %s", redisWait))
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		defer sharedUnlock(ctx, token)
+		// it may have been stored between our read and taking the lock
+		if s, ok, err := sharedLoad(c); err == nil && ok {
+			adoptShared(ctx, s)
+			return s.Profiles, s.GeneratedAt, nil
+		}
+		c.Close() // it would sit idle for the whole refresh
+		stats, err := generateStats(ctx, liveRefresh.publish)
+		at := clock()
+		if err == nil {
+			if err := sharedStore(stats, at); err != nil {
+				logf(ctx, "Redis write failed: %v\n", err)
+			}
+		}
+		return stats, at, err
+	}
+}
+
+// adoptShared updates what generateStats would have updated locally from
+// stats another replica generated: the failure list, the OFFLINE_FALLBACK
+// values and the profile gauges.
+func adoptShared(ctx context.Context, s sharedStats) {
+	failuresMu.Lock()
+	failures = make(map[string]ProfileFailure, len(s.Failures))
+	for _, f := range s.Failures {
+		failures[f.Name] = f
+	}
+	failuresMu.Unlock()
+	for _, p := range s.Profiles {
+		if !p.Offline {
+			rememberGood(p)
+		}
+	}
+	if err := saveLastGood(); err != nil {
+		logf(ctx, "Saving OFFLINE_CACHE_FILE failed: %v\n", err)
+	}
+	profilesTotal.Store(s.Total)
+	profilesOK.Store(s.Total - s.Failed)
+	profilesFailed.Store(s.Failed)
+	lastRefreshMs.Store(s.RefreshMs)
+}
+
+func sharedLoad(c *redisConn) (sharedStats, bool, error) {
+	var s sharedStats
+	reply, err := c.do("GET", redisKey+":stats")
+	if err != nil || reply == nil {
+		return s, false, err
+	}
+	data, ok := reply.(string)
+	if !ok {
+		return s, false, fmt.Errorf("unexpected GET reply %T", reply)
+	}
+	if err := json.Unmarshal([]byte(data), &s); err != nil {
+		return s, false, err
+	}
+	for i, p := range s.Profiles {
+		s.Profiles[i].Hosts = s.Hosts[p.Name]
+		s.Profiles[i].Activity = s.Activity[p.Name]
+	}
+	return s, true, nil
+}
+
+func sharedStore(stats []ProfileStats, at time.Time) error {
+	// generateStats just set the gauges and failures, and the latch keeps
+	// other refreshes from changing them in between
+	s := sharedStats{
+		GeneratedAt: at,
+		Profiles:    stats,
+		Hosts:       map[string][]HostSnapshots{},
+		Activity:    map[string][]snapshotEntry{},
+		Failures:    currentFailures(),
+		Total:       profilesTotal.Load(),
+		Failed:      profilesFailed.Load(),
+		RefreshMs:   lastRefreshMs.Load(),
+	}
+	for _, p := range stats {
+		if len(p.Hosts) > 0 {
+			s.Hosts[p.Name] = p.Hosts
+		}
+		if len(p.Activity) > 0 {
+			s.Activity[p.Name] = p.Activity
+		}
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	ttl := cacheTTLFor(stats)
+	_, err = redisDo("SET", redisKey+":stats", string(data), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// cacheTTLFor is the TTL runRefresh will give these stats.
+func cacheTTLFor(stats []ProfileStats) time.Duration {
+	if !conf().cacheSecondsSet {
+		return scheduleCacheTTL(stats)
+	}
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	return cachedTTL
+}
+
+// sharedLock takes the refresh lock. It expires after REDIS_LOCK_SECONDS in
+// case its holder dies mid-refresh.
+func sharedLock(c *redisConn) (token string, ok bool, err error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", false, err
+	}
+	token = hex.EncodeToString(b)
+	reply, err := c.do("SET", redisKey+":lock", token, "NX", "PX", strconv.FormatInt(redisLockTTL.Milliseconds(), 10))
+	return token, err == nil && reply == "OK", err
+}
+
+// unlockScript deletes the lock only if it is still ours; it may have
+// expired and been taken by another replica.
+const unlockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) end return 0`
+
+func sharedUnlock(ctx context.Context, token string) {
+	if _, err := redisDo("EVAL", unlockScript, "1", redisKey+":lock", token); err != nil {
+		logf(ctx, "Redis unlock failed: %v\n", err)
+	}
+}
+
+/* minimal RESP client */
+
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// redisConn is a connection to REDIS_URL, authenticated and with the
+// database selected. It is not safe for concurrent use.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// redisDo runs one command on a fresh connection. Apart from the polling in
+// sharedGenerate, the shared cache talks to Redis once or twice per refresh,
+// so there is no pool.
+func redisDo(args ...string) (interface{}, error) {
+	c, err := dialRedis()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.do(args...)
+}
+
+func dialRedis() (*redisConn, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "redis":
+		conn, err = dialer.Dial("tcp", addr)
+	case "rediss":
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("unsupported REDIS_URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	if pw, ok := u.User.Password(); ok {
+		auth := []string{"AUTH"}
+		if name := u.User.Username(); name != "" {
+			auth = append(auth, name)
+		}
+		if _, err := c.do(append(auth, pw)...); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" && db != "0" {
+		if _, err := c.do("SELECT", db); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// do runs one command and returns its reply: a string, an int64, nil or a
+// []interface{} of those.
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	_ = c.conn.SetDeadline(time.Now().Add(redisTimeout))
+	if err := writeRESP(c.conn, args); err != nil {
+		return nil, err
+	}
+	reply, err := readRESP(c.r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", args[0], err)
+	}
+	return reply, nil
+}
+
+func (c *redisConn) Close() error { return c.conn.Close() }
+
+func writeRESP(w io.Writer, args []string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty RESP line")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err // $-1 is nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		out := make([]interface{}, n)
+		for i := range out {
+			if out[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unexpected RESP reply %q", line)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRedis speaks just enough RESP for the shared cache: GET, SET with NX
+// and PX (expiry is ignored), the unlock EVAL, AUTH and SELECT.
+type fakeRedis struct {
+	mu    sync.Mutex
+	data  map[string]string
+	conns atomic.Int64
+}
+
+func startFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{data: map[string]string{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.conns.Add(1)
+			go f.serve(conn)
+		}
+	}()
+	old := redisURL
+	redisURL = "redis://" + ln.Addr().String()
+	t.Cleanup(func() {
+		ln.Close()
+		redisURL = old
+	})
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		req, err := readRESP(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, a := range req.([]interface{}) {
+			args = append(args, a.(string))
+		}
+		fmt.Fprint(conn, f.reply(args))
+	}
+}
+
+func (f *fakeRedis) reply(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		v, ok := f.data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "SET":
+		if _, ok := f.data[args[1]]; ok && len(args) > 3 && args[3] == "NX" {
+			return "$-1\r\n"
+		}
+		f.data[args[1]] = args[2]
+		return "+OK\r\n"
+	case "EVAL": // the unlock script
+		if f.data[args[3]] != args[4] {
+			return ":0\r\n"
+		}
+		delete(f.data, args[3])
+		return ":1\r\n"
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func (f *fakeRedis) set(key, value string) {
+	f.mu.Lock()
+	f.data[key] = value
+	f.mu.Unlock()
+}
+
+func TestSharedGenerateWaitStopsWithContext(t *testing.T) {
+	f := startFakeRedis(t)
+	f.set(redisKey+":lock", "other replica")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := sharedGenerate(ctx); err == nil {
+		t.Fatal("no error after the context ended")
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("returned after %s, want right after the context ended", took)
+	}
+	if n := f.conns.Load(); n != 1 {
+		t.Errorf("%d connections while polling, want 1", n)
+	}
+}
+
+func TestSharedGenerateWaitLimit(t *testing.T) {
+	useFixtures(t, "files")
+	f := startFakeRedis(t)
+	f.set(redisKey+":lock", "other replica")
+	old := redisWait
+	redisWait = 1500 * time.Millisecond
+	t.Cleanup(func() { redisWait = old })
+
+	stats, _, err := sharedGenerate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) == 0 {
+		t.Error("no stats, want a local refresh after REDIS_WAIT_SECONDS")
+	}
+	if n := f.conns.Load(); n != 1 {
+		t.Errorf("%d connections while polling, want 1", n)
+	}
+}
+
+func TestSharedGenerateAdopts(t *testing.T) {
+	f := startFakeRedis(t)
+	failuresMu.Lock()
+	oldFailures := failures
+	failures = map[string]ProfileFailure{"gone": {Name: "gone"}}
+	failuresMu.Unlock()
+	t.Cleanup(func() {
+		failuresMu.Lock()
+		failures = oldFailures
+		failuresMu.Unlock()
+	})
+
+	at := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	data, err := json.Marshal(sharedStats{
+		GeneratedAt: at,
+		Profiles:    []ProfileStats{{Name: "a"}, {Name: "b"}},
+		Failures:    []ProfileFailure{{Name: "c", Command: "raw-data", Error: "boom", Since: 42}},
+		Total:       3,
+		Failed:      1,
+		RefreshMs:   1234,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.set(redisKey+":stats", string(data))
+
+	stats, got, err := sharedGenerate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || !got.Equal(at) {
+		t.Errorf("got %d profiles generated at %s, want 2 at %s", len(stats), got, at)
+	}
+	fs := currentFailures()
+	if len(fs) != 1 || fs[0].Name != "c" || fs[0].Since != 42 {
+		t.Errorf("failures %+v, want the other replica's", fs)
+	}
+	if profilesTotal.Load() != 3 || profilesOK.Load() != 2 || profilesFailed.Load() != 1 || lastRefreshMs.Load() != 1234 {
+		t.Errorf("gauges %d/%d/%d %dms, want 3/2/1 1234ms",
+			profilesTotal.Load(), profilesOK.Load(), profilesFailed.Load(), lastRefreshMs.Load())
+	}
+}
+
+// TestSharedGenerateStores checks that the replica holding the lock stores
+// its stats with the failures and releases the lock.
+func TestSharedGenerateStores(t *testing.T) {
+	useFixtures(t, "files")
+	f := startFakeRedis(t)
+	if _, _, err := sharedGenerate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	data, stored := f.data[redisKey+":stats"]
+	_, locked := f.data[redisKey+":lock"]
+	f.mu.Unlock()
+	if !stored || locked {
+		t.Fatalf("stored %v, still locked %v, want stats and no lock", stored, locked)
+	}
+	var s sharedStats
+	if err := json.Unmarshal([]byte(data), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Total != 6 || s.Failed != 1 || len(s.Failures) != 1 || s.Failures[0].Name != "nooutput" {
+		t.Errorf("stored %d profiles, %d failed (%+v), want 6 with nooutput failing", s.Total, s.Failed, s.Failures)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/* ─── configuration reload ────────────────────────────────────────────────── */
+
+// settings are what SIGHUP can change without losing the cache; everything
+// else needs a restart. A reload swaps the whole value, so readers go
+// through conf() and never keep it across a refresh.
+type settings struct {
+	cacheSeconds       int
+	cacheSecondsSet    bool // false: TTL derived from the backup schedules
+	serveStale         bool // serve the old cache when a refresh fails
+	maxStale           int  // seconds, 0 = serve stale data forever
+	disabledStats      map[string]bool
+	timeouts           map[string]time.Duration
+	profileTimeout     time.Duration // PROFILE_TIMEOUT: budget for all commands of a profile, 0 = none
+	slowCommand        time.Duration // SLOW_COMMAND_SECONDS: log commands slower than this, 0 = off
+	staleThreshold     time.Duration // STALE_THRESHOLD_SECONDS: for profiles without schedule or override
+	healthMinSnapshots int
+	healthMaxAge       time.Duration              // 0: the profile's stale threshold, see staleThreshold
+	lockStaleAfter     time.Duration              // restic itself treats locks older than 30 minutes as stale
+	overrides          map[string]profileOverride // by profile directory
+}
+
+var (
+	settingsMu sync.RWMutex
+	current    settings
+
+	// configFile (CONFIG_FILE) holds KEY=VALUE lines for the reloadable
+	// settings, applied on top of the environment at startup and on SIGHUP.
+	configFile = os.Getenv("CONFIG_FILE")
+)
+
+func conf() settings {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return current
+}
+
+func loadSettings() settings {
+	overrides, err := parseOverrides(os.Getenv("PROFILE_OVERRIDES"))
+	if err != nil {
+		fmt.Printf("PROFILE_OVERRIDES: %v\n", err)
+	}
+	return settings{
+		cacheSeconds:       getCacheSeconds(),
+		cacheSecondsSet:    os.Getenv("CACHE_SECONDS") != "",
+		serveStale:         os.Getenv("SERVE_STALE") == "true",
+		maxStale:           getenvInt("MAX_STALE_SECONDS", 0),
+		disabledStats:      getDisabledStats(),
+		timeouts:           getTimeouts(),
+		profileTimeout:     time.Duration(getenvInt("PROFILE_TIMEOUT", 0)) * time.Second,
+		slowCommand:        time.Duration(getenvInt("SLOW_COMMAND_SECONDS", 0)) * time.Second,
+		staleThreshold:     time.Duration(getenvInt("STALE_THRESHOLD_SECONDS", 86400)) * time.Second,
+		healthMinSnapshots: getenvInt("HEALTH_MIN_SNAPSHOTS", 1),
+		healthMaxAge:       time.Duration(getenvInt("HEALTH_MAX_AGE_SECONDS", 0)) * time.Second,
+		lockStaleAfter:     time.Duration(getenvInt("LOCK_STALE_SECONDS", 1800)) * time.Second,
+		overrides:          overrides,
+	}
+}
+
+// reloadableKeys are the variables CONFIG_FILE may set, with the kind of
+// value each takes.
+var reloadableKeys = map[string]string{
+	"CACHE_SECONDS":            "positive",
+	"SERVE_STALE":              "bool",
+	"MAX_STALE_SECONDS":        "seconds",
+	"DISABLE_STATS":            "list",
+	"RESTIC_TIMEOUT":           "seconds",
+	"RESTIC_TIMEOUT_RAW":       "seconds",
+	"RESTIC_TIMEOUT_RESTORE":   "seconds",
+	"RESTIC_TIMEOUT_BLOBS":     "seconds",
+	"RESTIC_TIMEOUT_SNAPSHOTS": "seconds",
+	"RESTIC_TIMEOUT_PROBE":     "seconds",
+	"PROFILE_TIMEOUT":          "seconds",
+	"SLOW_COMMAND_SECONDS":     "seconds",
+	"STALE_THRESHOLD_SECONDS":  "positive",
+	"HEALTH_MIN_SNAPSHOTS":     "positive",
+	"HEALTH_MAX_AGE_SECONDS":   "seconds",
+	"LOCK_STALE_SECONDS":       "positive",
+	"PROFILE_OVERRIDES":        "overrides",
+}
+
+// reloadOnHUP reloads CONFIG_FILE on every SIGHUP. The cache is kept, new
+// settings apply from the next refresh (or request, for the health rules).
+func reloadOnHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := reloadConfig(); err != nil {
+			fmt.Printf("Reload failed, keeping the current settings: %v\n", err)
+			continue
+		}
+		fmt.Printf("Reloaded %s\n", configFile)
+	}
+}
+
+// reloadConfig applies CONFIG_FILE. An invalid file changes nothing: the
+// environment is put back and the running settings stay.
+func reloadConfig() error {
+	if configFile == "" {
+		return fmt.Errorf("CONFIG_FILE is not set, the environment of a running process cannot change")
+	}
+	vals, err := readConfigFile(configFile)
+	if err != nil {
+		return err
+	}
+	undo := applyConfigFile(vals)
+	s := loadSettings()
+	if s.cacheSecondsSet && bgRefresh > s.cacheSeconds { // a derived TTL is checked when it is recomputed
+		undo()
+		return fmt.Errorf("CACHE_SECONDS (%ds) is shorter than BACKGROUND_REFRESH (%ds)", s.cacheSeconds, bgRefresh)
+	}
+	settingsMu.Lock()
+	current = s
+	settingsMu.Unlock()
+	if s.cacheSecondsSet {
+		cacheMu.Lock()
+		cachedTTL = time.Duration(s.cacheSeconds) * time.Second
+		cacheMu.Unlock()
+	} // otherwise the next refresh derives it from the schedules again
+	return nil
+}
+
+// readConfigFile parses KEY=VALUE lines; empty lines and # comments are
+// skipped. Keys that need a restart and malformed values are errors.
+func readConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	vals := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, val, ok := strings.Cut(line, "=")
+		key, val = strings.TrimSpace(key), strings.Trim(strings.TrimSpace(val), `"`)
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want KEY=VALUE", path, n)
+		}
+		kind, known := reloadableKeys[key]
+		if !known {
+			return nil, fmt.Errorf("%s:%d: %s cannot be reloaded, set it in the environment", path, n, key)
+		}
+		if err := checkValue(kind, val); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", path, n, key, err)
+		}
+		vals[key] = val
+	}
+	return vals, scanner.Err()
+}
+
+func checkValue(kind, val string) error {
+	switch kind {
+	case "seconds", "positive":
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 || (kind == "positive" && n == 0) {
+			return fmt.Errorf("want a %s number, got %q", map[string]string{"seconds": "non-negative", "positive": "positive"}[kind], val)
+		}
+	case "overrides":
+		_, err := parseOverrides(val)
+		return err
+	case "bool":
+		if val != "true" && val != "false" {
+			return fmt.Errorf("want true or false, got %q", val)
+		}
+	}
+	return nil
+}
+
+// originalEnv remembers the environment from before CONFIG_FILE was first
+// applied, so a line removed from the file falls back to it (nil: unset).
+var originalEnv = map[string]*string{}
+
+// applyConfigFile puts vals into the environment, where loadSettings reads
+// them, and returns a func that restores the previous state.
+func applyConfigFile(vals map[string]string) (undo func()) {
+	before := map[string]*string{}
+	for k := range originalEnv {
+		before[k] = lookupEnv(k)
+	}
+	for k := range vals {
+		before[k] = lookupEnv(k)
+		if _, ok := originalEnv[k]; !ok {
+			originalEnv[k] = lookupEnv(k)
+		}
+	}
+	for k, v := range originalEnv {
+		setEnv(k, v)
+	}
+	for k, v := range vals {
+		os.Setenv(k, v)
+	}
+	return func() {
+		for k, v := range before {
+			setEnv(k, v)
+		}
+	}
+}
+
+func lookupEnv(k string) *string {
+	if v, ok := os.LookupEnv(k); ok {
+		return &v
+	}
+	return nil
+}
+
+func setEnv(k string, v *string) {
+	if v == nil {
+		os.Unsetenv(k)
+	} else {
+		os.Setenv(k, *v)
+	}
+}
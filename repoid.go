@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/* ─── repository ID ───────────────────────────────────────────────────────── */
+
+// repoConfigJSON is the output of `restic cat config --json`.
+type repoConfigJSON struct {
+	Version           int    `json:"version"`
+	ID                string `json:"id"`
+	ChunkerPolynomial string `json:"chunker_polynomial"`
+}
+
+// The repository ID only changes when a repository is re-initialised, so it
+// is cached apart from the stats for REPO_ID_CACHE_SECONDS.
+var (
+	repoIDTTL = time.Duration(getenvInt("REPO_ID_CACHE_SECONDS", 86400)) * time.Second
+
+	repoIDsMu sync.Mutex
+	repoIDs   = map[string]cachedRepoID{} // by directory, scoped rows share it
+)
+
+type cachedRepoID struct {
+	id      string
+	version int // repository format, 0 = unknown
+	at      time.Time
+}
+
+// repoID returns the repository ID and format version of a profile, running
+// `cat config` only when the cached one has expired. Failures are logged and
+// give "" and 0, they do not fail the profile.
+func repoID(ctx context.Context, name, dir string) (string, int) {
+	repoIDsMu.Lock()
+	c, ok := repoIDs[dir]
+	repoIDsMu.Unlock()
+	if ok && clock().Sub(c.at) < repoIDTTL {
+		return c.id, c.version
+	}
+
+	var cfg repoConfigJSON
+	if err := runAndParse(ctx, dir, "cat", "", []string{"config"}, &cfg); err != nil {
+		logf(ctx, "cat config for %s: %v\n", dir, err)
+		return c.id, c.version // keep the last known one
+	}
+	if ok && c.id != cfg.ID {
+		logf(ctx, "Repository of %s changed: %s -> %s\n", name, c.id, cfg.ID)
+	}
+	repoIDsMu.Lock()
+	repoIDs[dir] = cachedRepoID{id: cfg.ID, version: cfg.Version, at: clock()}
+	repoIDsMu.Unlock()
+	return cfg.ID, cfg.Version
+}
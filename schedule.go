@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* ─── backup schedule ─────────────────────────────────────────────────────── */
+
+// backupInterval reads the backup schedule from the profile directory's
+// resticprofile config and returns the expected time between two backups,
+// or 0 when there is no schedule we understand. YAML, TOML and JSON configs
+// are looked at; with several profiles in one file the most frequent
+// backup schedule wins.
+func backupInterval(dir string) time.Duration {
+	var best time.Duration
+	for _, name := range profileConfigNames {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		var exprs map[string][]string // by profile
+		switch filepath.Ext(name) {
+		case ".json":
+			exprs = jsonSchedules(data)
+		case ".toml":
+			exprs = tomlSchedules(string(data))
+		case ".yaml", ".yml":
+			exprs = yamlSchedules(string(data))
+		}
+		for _, e := range exprs {
+			if d := combinedInterval(e); d > 0 && (best == 0 || d < best) {
+				best = d
+			}
+		}
+	}
+	return best
+}
+
+// scheduleStaleThreshold is how long a profile may go without a snapshot
+// before it counts as stale: one interval plus some slack for slow backups
+// (daily -> 25h, weekly -> 7d7h).
+func scheduleStaleThreshold(interval time.Duration) time.Duration {
+	return interval + max(interval/24, time.Hour)
+}
+
+// scheduleCacheTTL derives a cache TTL from the backup intervals: a quarter
+// of the most frequent one, but never longer than the default TTL nor
+// shorter than a minute.
+func scheduleCacheTTL(stats []ProfileStats) time.Duration {
+	ttl := time.Duration(defaultCache) * time.Second
+	for _, p := range stats {
+		if p.ExpectedIntervalSeconds > 0 {
+			ttl = min(ttl, time.Duration(p.ExpectedIntervalSeconds)*time.Second/4)
+		}
+	}
+	return max(ttl, time.Minute)
+}
+
+// diskScheduleTTL is scheduleCacheTTL for the schedules currently in the
+// profile configs under DATA_ROOT, before any refresh has run.
+func diskScheduleTTL() time.Duration {
+	dirs, _ := listProfiles() // unreadable DATA_ROOT: the default TTL
+	stats := make([]ProfileStats, len(dirs))
+	for i, dir := range dirs {
+		stats[i].ExpectedIntervalSeconds = int64(backupInterval(filepath.Join(dataRoot, dir)).Seconds())
+	}
+	return scheduleCacheTTL(stats)
+}
+
+// combinedInterval turns several schedule expressions into one average
+// interval: "02:00" and "14:00" together are every 12 hours.
+func combinedInterval(exprs []string) time.Duration {
+	var perDay float64
+	for _, e := range exprs {
+		perDay += runsPerDay(e)
+	}
+	if perDay == 0 {
+		return 0
+	}
+	return time.Duration(float64(24*time.Hour) / perDay).Round(time.Second)
+}
+
+var scheduleKeywords = map[string]float64{
+	"minutely":     24 * 60,
+	"hourly":       24,
+	"daily":        1,
+	"weekly":       1.0 / 7,
+	"monthly":      1.0 / 30,
+	"quarterly":    1.0 / 91,
+	"semiannually": 1.0 / 182,
+	"yearly":       1.0 / 365,
+	"annually":     1.0 / 365,
+}
+
+// runsPerDay estimates how often a systemd calendar expression as used by
+// resticprofile ("daily", "Mon..Fri 02:00", "*-*-* *:0/15") fires per day.
+// It returns 0 for anything it cannot parse.
+func runsPerDay(expr string) float64 {
+	expr = strings.ToLower(strings.TrimSpace(expr))
+	if n, ok := scheduleKeywords[expr]; ok {
+		return n
+	}
+	fields := strings.Fields(expr)
+	if len(fields) == 0 {
+		return 0
+	}
+	days := 1.0
+	if strings.ContainsAny(fields[0], "abcdefghijklmnopqrstuvwxyz") {
+		n := countWeekdays(fields[0])
+		if n == 0 {
+			return 0
+		}
+		days = float64(n) / 7
+		fields = fields[1:]
+	}
+	if len(fields) > 0 && strings.Contains(fields[0], "-") {
+		parts := strings.Split(fields[0], "-")
+		if len(parts) != 3 {
+			return 0
+		}
+		months, ok1 := countValues(parts[1], 1, 12)
+		dom, ok2 := countValues(parts[2], 1, 31)
+		if !ok1 || !ok2 {
+			return 0
+		}
+		days *= float64(months) / 12 * float64(dom) / 31
+		fields = fields[1:]
+	}
+	runs := 1.0 // no time means 00:00
+	if len(fields) > 0 {
+		parts := strings.Split(fields[0], ":")
+		if len(parts) < 2 {
+			return 0
+		}
+		hours, ok1 := countValues(parts[0], 0, 23)
+		minutes, ok2 := countValues(parts[1], 0, 59)
+		if !ok1 || !ok2 {
+			return 0
+		}
+		runs = float64(hours * minutes)
+		fields = fields[1:]
+	}
+	if len(fields) > 0 {
+		return 0
+	}
+	return days * runs
+}
+
+var weekdays = []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}
+
+// countWeekdays counts the days in "mon", "mon,fri" or "mon..fri".
+func countWeekdays(s string) int {
+	n := 0
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(part, "..")
+		a, b := weekdayIndex(from), weekdayIndex(to)
+		if !isRange {
+			b = a
+		}
+		if a < 0 || b < 0 || b < a {
+			return 0
+		}
+		n += b - a + 1
+	}
+	return n
+}
+
+func weekdayIndex(s string) int {
+	for i, d := range weekdays {
+		if strings.HasPrefix(s, d) {
+			return i
+		}
+	}
+	return -1
+}
+
+// countValues counts how many values between lo and hi a calendar field
+// matches: "*", "5", "1,15", "8..18", "0/15" or "*/2".
+func countValues(s string, lo, hi int) (int, bool) {
+	n := 0
+	for _, part := range strings.Split(s, ",") {
+		start, step, hasStep := strings.Cut(part, "/")
+		if !hasStep {
+			step = "1"
+		}
+		st, err := strconv.Atoi(step)
+		if err != nil || st <= 0 {
+			return 0, false
+		}
+		a, b := lo, hi
+		switch from, to, isRange := strings.Cut(start, ".."); {
+		case start == "*":
+		case isRange:
+			if a, err = strconv.Atoi(from); err != nil {
+				return 0, false
+			}
+			if b, err = strconv.Atoi(to); err != nil {
+				return 0, false
+			}
+		default:
+			if a, err = strconv.Atoi(start); err != nil {
+				return 0, false
+			}
+			if !hasStep {
+				b = a
+			}
+		}
+		if a < lo || b > hi || b < a {
+			return 0, false
+		}
+		n += int(math.Floor(float64(b-a)/float64(st))) + 1
+	}
+	return n, true
+}
+
+// jsonSchedules returns the "schedule" values of every profile's "backup"
+// section.
+func jsonSchedules(data []byte) map[string][]string {
+	var cfg map[string]interface{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil
+	}
+	out := map[string][]string{}
+	for name, profile := range cfg {
+		p, _ := profile.(map[string]interface{})
+		backup, _ := p["backup"].(map[string]interface{})
+		switch s := backup["schedule"].(type) {
+		case string:
+			out[name] = append(out[name], s)
+		case []interface{}:
+			for _, e := range s {
+				if str, ok := e.(string); ok {
+					out[name] = append(out[name], str)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// tomlSchedules returns the schedule values below [<profile>.backup] tables.
+func tomlSchedules(data string) map[string][]string {
+	out := map[string][]string{}
+	profile := "" // set inside a backup table
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			var ok bool
+			if profile, ok = strings.CutSuffix(strings.Trim(line, "[] "), ".backup"); !ok {
+				profile = ""
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if profile != "" && ok && strings.TrimSpace(key) == "schedule" {
+			out[profile] = append(out[profile], scheduleValues(value)...)
+		}
+	}
+	return out
+}
+
+// yamlSchedules returns the schedule values nested in each profile's
+// "backup" mapping, written inline, as a flow list or as a block list.
+func yamlSchedules(data string) map[string][]string {
+	type level struct {
+		indent int
+		key    string
+	}
+	out := map[string][]string{}
+	var stack []level
+	var profile string
+	listIndent := -1 // indentation of the "schedule:" key whose list we are reading
+	for _, line := range strings.Split(data, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if listIndent >= 0 {
+			if item, ok := strings.CutPrefix(trimmed, "- "); ok && indent >= listIndent {
+				out[profile] = append(out[profile], scheduleValues(item)...)
+				continue
+			}
+			listIndent = -1
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok || strings.HasPrefix(trimmed, "-") {
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		if key == "schedule" && len(stack) > 0 && stack[len(stack)-1].key == "backup" {
+			profile = stack[0].key
+			if strings.TrimSpace(value) == "" {
+				listIndent = indent
+			} else {
+				out[profile] = append(out[profile], scheduleValues(value)...)
+			}
+		}
+		stack = append(stack, level{indent, key})
+	}
+	return out
+}
+
+// scheduleValues splits `"daily"` or `["Mon,Fri 02:00", "Sat 04:00"]` into
+// the individual expressions.
+func scheduleValues(v string) []string {
+	v = strings.TrimSpace(v)
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	list, ok := strings.CutPrefix(v, "[")
+	if !ok {
+		if v = strings.Trim(v, `"'`); v == "" {
+			return nil
+		}
+		return []string{v}
+	}
+	// split on commas outside quotes, "Mon,Fri 02:00" is one expression
+	var out []string
+	var cur strings.Builder
+	var quote rune
+	flush := func() {
+		if e := strings.Trim(strings.TrimSpace(cur.String()), `"'`); e != "" {
+			out = append(out, e)
+		}
+		cur.Reset()
+	}
+	for _, c := range strings.TrimSuffix(list, "]") {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == ',':
+			flush()
+			continue
+		}
+		cur.WriteRune(c)
+	}
+	flush()
+	return out
+}
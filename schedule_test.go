@@ -0,0 +1,231 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRunsPerDay(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		want float64
+	}{
+		{"daily", 1},
+		{"Hourly", 24},
+		{"weekly", 1.0 / 7},
+		{"02:00", 1},
+		{"*-*-* 02:00", 1},
+		{"*-*-* 02:00:30", 1},
+		{"*:0/15", 96},
+		{"*-*-* *:00", 24},
+		{"8..18:00", 11},
+		{"02,14:00", 2},
+		{"Mon..Fri 02:00", 5.0 / 7},
+		{"Sat,Sun 04:00", 2.0 / 7},
+		{"Mon", 1.0 / 7},
+		{"*-*-01 03:00", 1.0 / 31},
+		{"*-01,07-01 03:00", 2.0 / 12 / 31},
+		{"", 0},
+		{"every day", 0},
+		{"Fri..Mon 02:00", 0},
+		{"25:00", 0},
+		{"*:0/0", 0},
+		{"*-*-* 02:00 UTC", 0},
+		{"2025-13-01", 0},
+	} {
+		if got := runsPerDay(tc.expr); !approx(got, tc.want) {
+			t.Errorf("runsPerDay(%q) = %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func approx(a, b float64) bool {
+	return a-b < 1e-9 && b-a < 1e-9
+}
+
+func TestCombinedInterval(t *testing.T) {
+	for _, tc := range []struct {
+		exprs []string
+		want  time.Duration
+	}{
+		{[]string{"daily"}, 24 * time.Hour},
+		{[]string{"02:00", "14:00"}, 12 * time.Hour},
+		{[]string{"Mon..Fri 02:00", "Sat 04:00"}, 28 * time.Hour},
+		{[]string{"nonsense"}, 0},
+		{nil, 0},
+	} {
+		if got := combinedInterval(tc.exprs); got != tc.want {
+			t.Errorf("combinedInterval(%q) = %s, want %s", tc.exprs, got, tc.want)
+		}
+	}
+}
+
+func TestScheduleValues(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []string
+	}{
+		{` "daily"`, []string{"daily"}},
+		{`'*:0/15' # every quarter hour`, []string{"*:0/15"}},
+		{`["Mon,Fri 02:00", 'Sat 04:00']`, []string{"Mon,Fri 02:00", "Sat 04:00"}},
+		{`[]`, nil},
+		{`""`, nil},
+	} {
+		if got := scheduleValues(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("scheduleValues(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestYAMLSchedules(t *testing.T) {
+	got := yamlSchedules(`version: "1"
+
+# comment: not a schedule
+global:
+  schedule: hourly # not in a backup section
+inline:
+  backup:
+    schedule: "daily"
+flow:
+  backup:
+    source: [/home]
+    schedule: ["Mon..Fri 02:00", "Sat 04:00"]
+block:
+  retention:
+    schedule: minutely
+  backup:
+    schedule:
+      - "02:00"
+      - 14:00
+    verbose: true
+`)
+	want := map[string][]string{
+		"inline": {"daily"},
+		"flow":   {"Mon..Fri 02:00", "Sat 04:00"},
+		"block":  {"02:00", "14:00"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTOMLSchedules(t *testing.T) {
+	got := tomlSchedules(`version = "1"
+
+[default]
+repository = "local:/srv/restic"
+
+[default.backup]
+source = ["/home"]
+schedule = "daily"
+
+[db.backup]
+schedule = ["02:00", "14:00"]
+
+[db.retention]
+schedule = "hourly"
+`)
+	want := map[string][]string{
+		"default": {"daily"},
+		"db":      {"02:00", "14:00"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestJSONSchedules(t *testing.T) {
+	got := jsonSchedules([]byte(`{
+		"version": "1",
+		"default": {"backup": {"schedule": "daily"}},
+		"db": {"backup": {"schedule": ["02:00", 3, "14:00"]}},
+		"other": {"retention": {"schedule": "hourly"}}
+	}`))
+	want := map[string][]string{
+		"default": {"daily"},
+		"db":      {"02:00", "14:00"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := jsonSchedules([]byte("{")); got != nil {
+		t.Errorf("invalid JSON: got %q, want nil", got)
+	}
+}
+
+// TestBackupInterval checks that the most frequent schedule of all config
+// files wins.
+func TestBackupInterval(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := backupInterval(dir); got != 0 {
+		t.Errorf("no config: got %s, want 0", got)
+	}
+	write("profiles.yaml", "a:\n  backup:\n    schedule: daily\nb:\n  backup:\n    schedule: weekly\n")
+	if got := backupInterval(dir); got != 24*time.Hour {
+		t.Errorf("yaml: got %s, want 24h", got)
+	}
+	write("profiles.toml", "[c.backup]\nschedule = \"*:00\"\n")
+	if got := backupInterval(dir); got != time.Hour {
+		t.Errorf("yaml and toml: got %s, want 1h", got)
+	}
+}
+
+func TestScheduleCacheTTL(t *testing.T) {
+	for _, tc := range []struct {
+		intervals []int64
+		want      time.Duration
+	}{
+		{nil, defaultCache * time.Second},
+		{[]int64{0}, defaultCache * time.Second},
+		{[]int64{86400}, defaultCache * time.Second},
+		{[]int64{86400, 3600}, 15 * time.Minute},
+		{[]int64{60}, time.Minute},
+	} {
+		stats := make([]ProfileStats, len(tc.intervals))
+		for i, s := range tc.intervals {
+			stats[i].ExpectedIntervalSeconds = s
+		}
+		if got := scheduleCacheTTL(stats); got != tc.want {
+			t.Errorf("%v: got %s, want %s", tc.intervals, got, tc.want)
+		}
+	}
+}
+
+// TestValidateConfigDerivedTTL checks that BACKGROUND_REFRESH is clamped to
+// the TTL the schedules give when CACHE_SECONDS is unset.
+func TestValidateConfigDerivedTTL(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "often"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "often", "profiles.yaml"), []byte("default:\n  backup:\n    schedule: \"*:0/5\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	useFixtures(t, "files")
+	dataRoot = root
+	s := conf()
+	s.cacheSeconds, s.cacheSecondsSet = defaultCache, false
+	setSettings(s)
+	oldBg, oldStrict := bgRefresh, strictConfig
+	t.Cleanup(func() { bgRefresh, strictConfig = oldBg, oldStrict })
+
+	bgRefresh, strictConfig = 600, true
+	if err := validateConfig(); err == nil {
+		t.Error("STRICT_CONFIG: no error for BACKGROUND_REFRESH above the derived TTL")
+	}
+	bgRefresh, strictConfig = 600, false
+	if err := validateConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if bgRefresh != 75 {
+		t.Errorf("BACKGROUND_REFRESH clamped to %ds, want the 75s derived from a 5 minute schedule", bgRefresh)
+	}
+}
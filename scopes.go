@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/* ─── scoped profiles ─────────────────────────────────────────────────────── */
+
+// profileTarget is one row of the stats: a profile directory, optionally
+// narrowed to the snapshots of one host or tag.
+type profileTarget struct {
+	Name  string   // dir, or dir@value for a scope
+	Dir   string   // relative to DATA_ROOT
+	Scope string   // "host:web1", "tag:db" or ""
+	Args  []string // filter flags for stats and snapshots
+}
+
+// profileScopes maps a profile directory to its scopes (PROFILE_SCOPES,
+// "shared=host:web1,host:web2;nas=tag:photos"), using the groups syntax.
+var profileScopes = parseScopes(os.Getenv("PROFILE_SCOPES"))
+
+func parseScopes(v string) map[string][]string {
+	out := map[string][]string{}
+	for _, g := range parseGroups(v) {
+		for _, s := range g.Members {
+			kind, value, _ := strings.Cut(s, ":")
+			if (kind != "host" && kind != "tag") || value == "" {
+				fmt.Printf("PROFILE_SCOPES: ignoring %q for %s, expected host:NAME or tag:NAME\n", s, g.Name)
+				continue
+			}
+			out[g.Name] = append(out[g.Name], s)
+		}
+	}
+	return out
+}
+
+// expandScopes turns profile directories into targets. A directory with
+// scopes yields one target per scope instead of one for the whole repo.
+func expandScopes(dirs []string) []profileTarget {
+	var out []profileTarget
+	for _, d := range dirs {
+		scopes, ok := profileScopes[d]
+		if !ok {
+			out = append(out, profileTarget{Name: d, Dir: d})
+			continue
+		}
+		for _, s := range scopes {
+			kind, value, _ := strings.Cut(s, ":")
+			out = append(out, profileTarget{
+				Name:  d + "@" + value,
+				Dir:   d,
+				Scope: s,
+				Args:  []string{"--" + kind, value},
+			})
+		}
+	}
+	return out
+}
+
+// targetByName finds the target for a name as used in the stats ("dir" or
+// "dir@value").
+func targetByName(name string) (profileTarget, bool) {
+	dir, _, _ := strings.Cut(name, "@")
+	for _, t := range expandScopes([]string{dir}) {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return profileTarget{}, false
+}
+
+func (t profileTarget) path() string {
+	return filepath.Join(dataRoot, t.Dir)
+}
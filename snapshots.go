@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* ─── snapshots per host ──────────────────────────────────────────────────── */
+
+// ProfileSnapshots is one profile of /stats/snapshots.
+type ProfileSnapshots struct {
+	Name             string          `json:"name"`
+	Snapshots        int             `json:"snapshots"`
+	LastSnapshot     string          `json:"last_snapshot"` // human readable
+	LastSnapshotUnix int64           `json:"last_snapshot_unix"`
+	LastSnapshotISO  string          `json:"last_snapshot_iso"`
+	Hosts            []HostSnapshots `json:"hosts,omitempty"`      // ?group_by=host, sorted by host
+	SinceUnix        int64           `json:"since_unix,omitempty"` // ?since=, start of the window
+	Paths            []PathSnapshot  `json:"paths,omitempty"`      // ?since=, latest snapshot per path in the window
+}
+
+// snapshotsHandler serves /stats/snapshots: the snapshot count and latest
+// snapshot per profile, and with ?group_by=host the same per hostname, to see
+// which machines of a shared repository are still backing up. Counts cover
+// the snapshots read, so SNAPSHOTS_LIMIT and SKIP_STATS lower them.
+// ?since=7d only counts the snapshots of that window and adds the latest
+// snapshot per path within it.
+func snapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy != "" && groupBy != "host" {
+		http.Error(w, "group_by must be host", http.StatusBadRequest)
+		return
+	}
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		d, err := parseSince(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		since = clock().Add(-d)
+	}
+	res, err := getStats(r.Context())
+	if err != nil {
+		statsError(w, err)
+		return
+	}
+	out := make([]ProfileSnapshots, 0, len(res))
+	for _, p := range res {
+		if !since.IsZero() {
+			out = append(out, windowSnapshots(p.Name, p.Activity, since, groupBy))
+			continue
+		}
+		ps := ProfileSnapshots{
+			Name:             p.Name,
+			LastSnapshot:     p.LastSnapshot,
+			LastSnapshotUnix: p.LastSnapshotUnix,
+			LastSnapshotISO:  p.LastSnapshotISO,
+		}
+		for _, h := range p.Hosts {
+			ps.Snapshots += h.Snapshots
+		}
+		if groupBy == "host" {
+			ps.Hosts = p.Hosts
+		}
+		out = append(out, ps)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = writeJSONResponse(w, http.StatusOK, out, jsonOptions(r))
+}
+
+// windowSnapshots is a profile of /stats/snapshots?since=, summarised from
+// the snapshots taken at or after since.
+func windowSnapshots(name string, snaps []snapshotEntry, since time.Time, groupBy string) ProfileSnapshots {
+	var in []snapshotEntry
+	for _, s := range snaps {
+		if t, err := time.Parse(time.RFC3339, s.Time); err == nil && !t.Before(since) {
+			in = append(in, s)
+		}
+	}
+	sum := summariseSnapshots(in)
+	ps := ProfileSnapshots{
+		Name:             name,
+		Snapshots:        len(in),
+		LastSnapshot:     sum.LastSnapshot,
+		LastSnapshotUnix: unixOrZero(sum.Latest),
+		LastSnapshotISO:  isoOrEmpty(sum.Latest),
+		SinceUnix:        since.Unix(),
+		Paths:            sum.Paths,
+	}
+	sort.Slice(ps.Paths, func(i, j int) bool { return ps.Paths[i].Path < ps.Paths[j].Path })
+	if groupBy == "host" {
+		ps.Hosts = sum.Hosts
+	}
+	return ps
+}
+
+// activity keeps what ?since= needs of each snapshot.
+func activity(snaps []snapshotEntry) []snapshotEntry {
+	out := make([]snapshotEntry, len(snaps))
+	for i, s := range snaps {
+		out[i] = snapshotEntry{Time: s.Time, Paths: s.Paths, Hostname: s.Hostname, ID: s.ID, ShortID: s.ShortID}
+	}
+	return out
+}
+
+// parseSince parses a relative window like 90m, 36h, 7d or 2w; Go
+// durations such as 1h30m work too.
+func parseSince(v string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if n, ok := strings.CutSuffix(v, suffix); ok {
+			if c, err := strconv.Atoi(n); err == nil && c > 0 {
+				return time.Duration(c) * unit, nil
+			}
+		}
+	}
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("since must be a duration like 7d, 2w or 36h, got %q", v)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+/* ─── recorded output (SOURCE_MODE=files) ─────────────────────────────────── */
+
+// sourceMode is "commands" (run restic) or "files": read the JSON an
+// external job has saved into each profile directory, for hosts that cannot
+// reach the repositories themselves.
+var sourceMode = getenvOr("SOURCE_MODE", "commands")
+
+// recordedFile names the file holding a command's output: the stats mode
+// ("raw-data.json"), "snapshots.json" or "config.json" for `cat config`.
+func recordedFile(cmdName, mode string, extraArgs []string) string {
+	switch {
+	case cmdName == "stats" && mode != "":
+		return mode + ".json"
+	case cmdName == "snapshots":
+		return "snapshots.json"
+	case cmdName == "cat" && len(extraArgs) > 0 && extraArgs[0] == "config":
+		return "config.json"
+	}
+	return ""
+}
+
+// readRecorded is runAndParse for SOURCE_MODE=files. The files are parsed
+// exactly like the command output, so log lines before the JSON are fine.
+func readRecorded(dir, cmdName, mode string, extraArgs []string, v interface{}) error {
+	name := recordedFile(cmdName, mode, extraArgs)
+	if name == "" {
+		return fmt.Errorf("%s is not available with SOURCE_MODE=files", cmdName)
+	}
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if jsonOnly {
+		if err := decodeJSON(f, v); err != nil {
+			if errors.Is(err, io.EOF) {
+				return errNoJSON
+			}
+			return fmt.Errorf("decode %s: %w", name, err)
+		}
+		return nil
+	}
+	found, err := scanJSON(f, io.Discard, cmdName, v)
+	if err == nil && !found {
+		err = errNoJSON
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+/* ─── live refresh stream (SSE) ───────────────────────────────────────────── */
+
+// refreshHub fans out profiles to /stats/stream clients while a refresh is
+// running. A subscriber that can't keep up is dropped; it still gets the
+// missing profiles from the final result.
+type refreshHub struct {
+	mu   sync.Mutex
+	subs map[chan ProfileStats]struct{}
+}
+
+var liveRefresh = &refreshHub{subs: map[chan ProfileStats]struct{}{}}
+
+func (h *refreshHub) subscribe() chan ProfileStats {
+	ch := make(chan ProfileStats, 64)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *refreshHub) unsubscribe(ch chan ProfileStats) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// publish is called by generateStats' workers for every finished profile.
+func (h *refreshHub) publish(p ProfileStats) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- p:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// streamHandler serves /stats/stream: one "profile" event per profile as it
+// is computed (or straight from the cache when it is fresh), followed by a
+// "complete" event, or an "error" event if the refresh failed.
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	o := jsonOptions(r)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	send := func(event string, v interface{}) {
+		eo := o
+		if event != "profile" {
+			eo.fields = nil // ?fields selects profile fields only
+		}
+		data, err := marshalJSON(v, eo)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+		flusher.Flush()
+	}
+	sent := map[string]bool{}
+	sendProfile := func(p ProfileStats) {
+		sent[p.Name] = true
+		send("profile", evaluateHealth(p))
+	}
+
+	type result struct {
+		res []ProfileStats
+		err error
+	}
+	ch := liveRefresh.subscribe()
+	done := make(chan result, 1)
+	go func() {
+		res, err := getStats(r.Context())
+		done <- result{res, err}
+	}()
+
+	var out result
+	events := ch
+wait:
+	for {
+		select {
+		case p, ok := <-events:
+			if !ok { // dropped as too slow
+				events = nil
+				continue
+			}
+			sendProfile(p)
+		case out = <-done:
+			break wait
+		case <-r.Context().Done():
+			liveRefresh.unsubscribe(ch)
+			return
+		}
+	}
+	liveRefresh.unsubscribe(ch)
+	if events != nil {
+		for p := range events {
+			sendProfile(p)
+		}
+	}
+
+	if out.err != nil {
+		send("error", map[string]string{"error": out.err.Error()})
+		return
+	}
+	// cache hits, group rows and anything a slow client missed
+	for _, p := range out.res {
+		if !sent[p.Name] {
+			sendProfile(p)
+		}
+	}
+	send("complete", map[string]int{"profiles": len(out.res)})
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// startSyslog fails, log/syslog only exists on Unix.
+func startSyslog(addr string) (stop func(), err error) {
+	return nil, errors.New("SYSLOG_ADDR is not supported on this platform")
+}
@@ -0,0 +1,62 @@
+//go:build unix
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/syslog"
+	"os"
+	"strings"
+)
+
+// startSyslog tees stdout, where all log lines (and restic's output) go, to
+// the syslog server at SYSLOG_ADDR. stdout keeps getting every line. The
+// returned func flushes what is still in the pipe; call it before exiting.
+func startSyslog(addr string) (stop func(), err error) {
+	network, raddr := syslogTarget(addr)
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, syslogTag)
+	if err != nil {
+		return nil, err
+	}
+	r, pw, err := os.Pipe()
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	out := os.Stdout
+	os.Stdout = pw
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			line := scanner.Text()
+			fmt.Fprintln(out, line)
+			if strings.Contains(line, "WARNING") {
+				_ = w.Warning(line)
+			} else {
+				_ = w.Info(line)
+			}
+		}
+	}()
+	return func() {
+		os.Stdout = out
+		pw.Close()
+		<-done
+		w.Close()
+	}, nil
+}
+
+// syslogTarget splits SYSLOG_ADDR: udp://host:514, tcp://host:514,
+// unix:///dev/log, a plain host:port (UDP), or "local" for the local daemon.
+func syslogTarget(addr string) (network, raddr string) {
+	if addr == "local" {
+		return "", ""
+	}
+	if network, raddr, ok := strings.Cut(addr, "://"); ok {
+		return network, raddr
+	}
+	return "udp", addr
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"sync"
+)
+
+/* ─── size trend ──────────────────────────────────────────────────────────── */
+
+const sizeHistoryLen = 32 // raw_bytes samples kept per profile
+
+var (
+	trendAlpha = getTrendAlpha() // SIZE_TREND_ALPHA, weight of the newest sample
+
+	sizeHistoryMu sync.Mutex
+	sizeHistory   = map[string]*sizeRing{} // by profile name
+)
+
+// sizeRing holds the last sizeHistoryLen raw_bytes values, oldest first
+// once wrapped around.
+type sizeRing struct {
+	samples [sizeHistoryLen]int64
+	next, n int
+}
+
+func (r *sizeRing) add(v int64) {
+	r.samples[r.next] = v
+	r.next = (r.next + 1) % sizeHistoryLen
+	r.n = min(r.n+1, sizeHistoryLen)
+}
+
+// values returns the samples from oldest to newest.
+func (r *sizeRing) values() []int64 {
+	out := make([]int64, 0, r.n)
+	for i := 0; i < r.n; i++ {
+		out = append(out, r.samples[(r.next-r.n+i+sizeHistoryLen)%sizeHistoryLen])
+	}
+	return out
+}
+
+// recordSize adds the current raw size of a profile to its history and
+// returns the trend: "growing" or "shrinking" when it is more than 0.5% away
+// from the EMA of the earlier samples, "stable" otherwise, and "unknown"
+// until there are two samples.
+func recordSize(name string, rawBytes int64) string {
+	sizeHistoryMu.Lock()
+	defer sizeHistoryMu.Unlock()
+	r := sizeHistory[name]
+	if r == nil {
+		r = &sizeRing{}
+		sizeHistory[name] = r
+	}
+	prev := r.values()
+	r.add(rawBytes)
+	if len(prev) == 0 {
+		return "unknown"
+	}
+
+	ema := float64(prev[0])
+	for _, v := range prev[1:] {
+		ema = trendAlpha*float64(v) + (1-trendAlpha)*ema
+	}
+	switch cur := float64(rawBytes); {
+	case cur > ema*1.005:
+		return "growing"
+	case cur < ema*0.995:
+		return "shrinking"
+	default:
+		return "stable"
+	}
+}
+
+// getTrendAlpha reads SIZE_TREND_ALPHA (0 < alpha <= 1, default 0.3).
+func getTrendAlpha() float64 {
+	if a, err := strconv.ParseFloat(os.Getenv("SIZE_TREND_ALPHA"), 64); err == nil && a > 0 && a <= 1 {
+		return a
+	}
+	return 0.3
+}
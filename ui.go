@@ -0,0 +1,21 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+	"os"
+)
+
+/* ─── status page ─────────────────────────────────────────────────────────── */
+
+//go:embed ui/index.html
+var uiPage []byte
+
+var enableUI = os.Getenv("ENABLE_UI") == "true"
+
+// uiHandler serves the status page. It loads "stats" relative to its own
+// URL, so it also works below ROUTE_PREFIX.
+func uiHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(uiPage)
+}
@@ -0,0 +1,177 @@
+package main
+
+import "net/http"
+
+/* ─── versioned response (v2) ─────────────────────────────────────────────── */
+
+// /stats keeps the flat v1 shape for existing clients; /stats/v2 (or
+// /stats?version=2) groups the same cached data into nested objects.
+
+const apiVersion = 2
+
+type statsResponseV2 struct {
+	APIVersion int              `json:"api_version"`
+	Profiles   []ProfileStatsV2 `json:"profiles"`
+}
+
+// Sizes separates the logical size (what a restore would write) from the
+// physical size (what the repository occupies on disk).
+type Sizes struct {
+	LogicalBytes     int64   `json:"logical_bytes"` // restore-size
+	LogicalHuman     string  `json:"logical_human"`
+	LogicalFiles     int64   `json:"logical_files"`
+	FilesPerSnapshot float64 `json:"files_per_snapshot,omitempty"`
+	PhysicalBytes    int64   `json:"physical_bytes"` // raw-data
+	PhysicalHuman    string  `json:"physical_human"`
+	PhysicalBlobs    int64   `json:"physical_blobs"`
+	Trend            string  `json:"trend,omitempty"`
+}
+
+type Compression struct {
+	UncompressedBytes int64   `json:"uncompressed_bytes"`
+	UncompressedHuman string  `json:"uncompressed_human"`
+	Ratio             float64 `json:"ratio"`
+	RatioHuman        string  `json:"ratio_human"`
+	SpaceSaving       float64 `json:"space_saving"`
+	SpaceSavingHuman  string  `json:"space_saving_human"`
+	SavedBytes        int64   `json:"saved_bytes"`
+	SavedHuman        string  `json:"saved_human"`
+	Progress          int64   `json:"progress"`
+}
+
+type Locks struct {
+	Count int  `json:"count"`
+	Stale bool `json:"stale"`
+}
+
+type Health struct {
+	Healthy bool     `json:"healthy"`
+	Reasons []string `json:"reasons,omitempty"`
+	Stale   bool     `json:"stale"`
+
+	Offline      bool  `json:"offline,omitempty"` // OFFLINE_FALLBACK
+	OfflineSince int64 `json:"offline_since_unix,omitempty"`
+}
+
+type Maintenance struct {
+	LastUnix int64 `json:"last_unix"` // 0 = no prune seen
+}
+
+type SnapshotInfo struct {
+	Count             int64          `json:"count"`
+	Last              string         `json:"last"` // human readable
+	LastUnix          int64          `json:"last_unix"`
+	LastISO           string         `json:"last_iso"`
+	LastID            string         `json:"last_id"`
+	LastAddedBytes    int64          `json:"last_added_bytes,omitempty"` // LAST_DELTA
+	PerDay            float64        `json:"per_day"`
+	LargestGapSeconds int64          `json:"largest_gap_seconds"`
+	ExpectedInterval  int64          `json:"expected_interval_seconds"`
+	Paths             []PathSnapshot `json:"paths"`
+	PathsOmitted      bool           `json:"paths_omitted,omitempty"`
+	CoveredPaths      int            `json:"covered_paths"`
+	OldestPathUnix    int64          `json:"oldest_path_unix"`
+}
+
+type ProfileStatsV2 struct {
+	Name        string   `json:"name"`
+	DisplayName string   `json:"display_name,omitempty"`
+	Members     []string `json:"members,omitempty"`
+	RepoID      string   `json:"repo_id"`
+	RepoVersion int      `json:"repo_version,omitempty"`
+	SourceDir   string   `json:"source_dir,omitempty"`
+	Scope       string   `json:"scope,omitempty"`
+
+	Size         Sizes         `json:"size"`
+	Compression  Compression   `json:"compression"`
+	Snapshots    SnapshotInfo  `json:"snapshots"`
+	Maintenance  Maintenance   `json:"maintenance"`
+	Locks        *Locks        `json:"locks,omitempty"` // CHECK_LOCKS
+	BlobsPerFile *BlobsPerFile `json:"blobs_per_file,omitempty"`
+	Health       Health        `json:"health"`
+
+	RefreshDurationMs int64    `json:"refresh_duration_ms"`
+	Warnings          []string `json:"warnings,omitempty"`
+}
+
+func toV2(p ProfileStats) ProfileStatsV2 {
+	return ProfileStatsV2{
+		Name:        p.Name,
+		DisplayName: p.DisplayName,
+		Members:     p.Members,
+		RepoID:      p.RepoID,
+		RepoVersion: p.RepoVersion,
+		SourceDir:   p.SourceDir,
+		Scope:       p.Scope,
+
+		Size: Sizes{
+			LogicalBytes:     p.RestoreBytes,
+			LogicalHuman:     p.RestoreHuman,
+			LogicalFiles:     p.RestoreFiles,
+			FilesPerSnapshot: p.FilesPerSnapshot,
+			PhysicalBytes:    p.RawBytes,
+			PhysicalHuman:    p.RawHuman,
+			PhysicalBlobs:    p.RawBlobs,
+			Trend:            p.SizeTrend,
+		},
+		Compression: Compression{
+			UncompressedBytes: p.UncompBytes,
+			UncompressedHuman: p.UncompHuman,
+			Ratio:             p.CompressRatio,
+			RatioHuman:        p.CompressRatioHuman,
+			SpaceSaving:       p.CompressionSavingPc,
+			SpaceSavingHuman:  p.CompressionSavingHuman,
+			SavedBytes:        p.SavedBytes,
+			SavedHuman:        p.SavedHuman,
+			Progress:          p.CompressionProgPct,
+		},
+		Snapshots: SnapshotInfo{
+			Count:             p.Snapshots,
+			Last:              p.LastSnapshot,
+			LastUnix:          p.LastSnapshotUnix,
+			LastISO:           p.LastSnapshotISO,
+			LastID:            p.LastSnapshotID,
+			LastAddedBytes:    p.LastSnapshotAdded,
+			PerDay:            p.SnapshotsPerDay,
+			LargestGapSeconds: p.LargestGapSeconds,
+			ExpectedInterval:  p.ExpectedIntervalSeconds,
+			Paths:             p.Paths,
+			PathsOmitted:      p.PathsOmitted,
+			CoveredPaths:      p.CoveredPaths,
+			OldestPathUnix:    p.OldestPathSnapshotUnix,
+		},
+		Maintenance:  Maintenance{LastUnix: p.LastMaintenance},
+		Locks:        v2Locks(p),
+		BlobsPerFile: p.BlobsPerFile,
+		Health:       v2Health(p),
+
+		RefreshDurationMs: p.RefreshDurationMs,
+		Warnings:          p.Warnings,
+	}
+}
+
+func v2Health(p ProfileStats) Health {
+	return Health{
+		Healthy: p.Healthy, Reasons: p.HealthReasons, Stale: p.IsStale,
+		Offline: p.Offline, OfflineSince: p.OfflineSince,
+	}
+}
+
+func statsV2(res []ProfileStats) statsResponseV2 {
+	out := statsResponseV2{APIVersion: apiVersion, Profiles: make([]ProfileStatsV2, len(res))}
+	for i, p := range res {
+		out.Profiles[i] = toV2(p)
+	}
+	return out
+}
+
+func statsV2Handler(w http.ResponseWriter, r *http.Request) {
+	serveStats(w, r, "2")
+}
+
+func v2Locks(p ProfileStats) *Locks {
+	if !checkLocksEnabled {
+		return nil
+	}
+	return &Locks{Count: p.Locks, Stale: p.HasStaleLock}
+}
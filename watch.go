@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+/* ─── watch mode ──────────────────────────────────────────────────────────── */
+
+var (
+	watchMode = os.Getenv("WATCH_MODE") == "true"
+	// a backup touches many files; wait for it to settle before refreshing
+	watchDebounce = time.Duration(getenvInt("WATCH_DEBOUNCE_SECONDS", 10)) * time.Second
+)
+
+// profileWatcher refreshes a profile shortly after something changed in its
+// directory, e.g. resticprofile writing its status file or, for a local
+// repository kept in the directory, a new file in snapshots/.
+type profileWatcher struct {
+	w *fsnotify.Watcher
+
+	mu     sync.Mutex
+	dirs   map[string]string // watched path -> profile dir
+	timers map[string]*time.Timer
+}
+
+// startWatcher watches all profile directories. Any error (typically
+// fs.inotify.max_user_watches being exhausted) is logged and leaves the
+// server with its time-based refresh only.
+func startWatcher() {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Printf("WATCH_MODE: %v; falling back to time-based refresh\n", err)
+		return
+	}
+	pw := &profileWatcher{w: w, dirs: map[string]string{}, timers: map[string]*time.Timer{}}
+	dirs, err := listProfiles()
+	if err == nil {
+		err = w.Add(dataRoot) // new profile directories
+	}
+	for _, d := range dirs {
+		if err != nil {
+			break
+		}
+		err = pw.add(d)
+	}
+	if err != nil {
+		fmt.Printf("WATCH_MODE: %v; falling back to time-based refresh\n", err)
+		w.Close()
+		return
+	}
+	fmt.Printf("Watching %d profile directories\n", len(dirs))
+	go safely("watcher", func() error { pw.run(); return nil })
+}
+
+// add watches a profile directory and the snapshots/ directory of a local
+// repository inside it (dir/snapshots or dir/*/snapshots).
+func (pw *profileWatcher) add(dir string) error {
+	root := filepath.Join(dataRoot, dir)
+	paths := []string{root}
+	for _, pattern := range []string{"snapshots", "*/snapshots"} {
+		m, _ := filepath.Glob(filepath.Join(root, pattern))
+		paths = append(paths, m...)
+	}
+	for _, p := range paths {
+		if err := pw.w.Add(p); err != nil {
+			return fmt.Errorf("watch %s: %w", p, err)
+		}
+		pw.mu.Lock()
+		pw.dirs[p] = dir
+		pw.mu.Unlock()
+	}
+	return nil
+}
+
+func (pw *profileWatcher) run() {
+	for {
+		select {
+		case ev, ok := <-pw.w.Events:
+			if !ok {
+				return
+			}
+			pw.handle(ev)
+		case err, ok := <-pw.w.Errors:
+			if !ok {
+				return
+			}
+			fmt.Printf("WATCH_MODE: %v\n", err)
+		}
+	}
+}
+
+func (pw *profileWatcher) handle(ev fsnotify.Event) {
+	if ev.Op == fsnotify.Chmod {
+		return
+	}
+	parent := filepath.Dir(ev.Name)
+	if parent == filepath.Clean(dataRoot) {
+		// a new profile shows up with the next full refresh; watch it already
+		if fi, err := os.Stat(ev.Name); ev.Has(fsnotify.Create) && err == nil && fi.IsDir() {
+			if err := pw.add(filepath.Base(ev.Name)); err != nil {
+				fmt.Printf("WATCH_MODE: %v\n", err)
+			}
+		}
+		return
+	}
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	dir, ok := pw.dirs[parent]
+	if !ok {
+		return
+	}
+	if t, ok := pw.timers[dir]; ok {
+		t.Reset(watchDebounce)
+		return
+	}
+	pw.timers[dir] = time.AfterFunc(watchDebounce, func() {
+		pw.mu.Lock()
+		delete(pw.timers, dir)
+		pw.mu.Unlock()
+		refreshWatched(dir)
+	})
+}
+
+// refreshWatched refreshes every row of a changed profile directory.
+func refreshWatched(dir string) {
+	if isDisabled(filepath.Join(dataRoot, dir)) {
+		return
+	}
+	for _, t := range expandScopes([]string{dir}) {
+		err := safely("watch refresh "+t.Name, func() error {
+			_, err := refreshProfile(context.Background(), t)
+			return err
+		})
+		if err != nil {
+			fmt.Printf("WATCH_MODE: refresh of %s failed: %v\n", t.Name, err)
+		} else {
+			fmt.Printf("WATCH_MODE: refreshed %s\n", t.Name)
+		}
+	}
+}